@@ -13,7 +13,17 @@ type Decoder interface {
 	// Open inialize decoder object.
 	Open(filename string) os.Error
 	// Read decode piece of data and returns raw PCM audio data.
+	// os.EOF error is returned when the end of stream is reached.
 	Read(buf []byte) (read int, err os.Error)
+	// SampleRate returns sample rate of the opened file.
+	SampleRate() int
+	// Channels returns number of channels of the opened file.
+	Channels() int
+	// Length returns total length of the opened file in seconds.
+	Length() int
+	// Seek moves decoding position to the given PCM frame (sample for every channel)
+	// from the beginning of the stream.
+	Seek(frame int64) os.Error
 	// Close releases decoder resources.
 	Close()
 }
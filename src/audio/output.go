@@ -4,8 +4,13 @@ package audio
 import (
 	"os"
 	"fmt"
+	"./logger"
+	"./config"
 )
 
+// Log of the audio subsystem.
+var log = logger.New("audio")
+
 // Output interface represents audio autput interface (ALSA, OSS, ...).
 type Output interface {
 	// Open opens output audio device.
@@ -40,13 +45,21 @@ func RegisterOutput(name string, fact outputFactory) {
 	outputFactories[name] = fact
 }
 
+// OutputAvailable returns true if output driver with the given name is registered.
+func OutputAvailable(name string) bool {
+	_, ok := outputFactories[name]
+
+	return ok
+}
+
 // GetOutput returns output interface for writing data to.
-func GetOutput() Output {
-	// TOOD: Make output configurable. Now alsa hard coded.
-	fact, ok := outputFactories["alsa"]
+func GetOutput() (output Output, err os.Error) {
+	name, _ := config.Configurations.GetString("audio.output")
+	fact, ok := outputFactories[name]
 	if !ok {
-		panic(fmt.Sprintf("Output '%s' is not available"))
+		log.Error("Output '%s' is not available", name)
+		return nil, os.NewError(fmt.Sprintf("Output '%s' is not available", name))
 	}
 
-	return fact()
+	return fact(), nil
 }
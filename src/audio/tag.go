@@ -10,6 +10,4 @@ type Tag struct {
 	Title string
 	// Track number.
 	Number string
-	// Track length
-	Length string
 }
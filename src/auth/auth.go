@@ -0,0 +1,297 @@
+// Auth package implements clients authentication and permission levels.
+//
+// Passwords are defined in the configuration file with the auth.passwords item,
+// which is the list of password@level pairs, e. g. "secret@admin, guest@read". Connections which didn't send a password get
+// the level defined with the auth.default item. It is "read" by default, so anybody
+// who can connect can't control the player. Set it to "control" or "admin" in the
+// configuration file if all clients are trusted, or give them passwords instead.
+//
+// Client host which sent too many incorrect passwords is locked out for a while:
+// all its passwords are rejected even correct ones.
+//
+// TLS clients can be authenticated with certificates signed by CA from the
+// tls.ca file. Level of the certificate is defined with the tls.clients item,
+// which is the list of common_name@level pairs.
+package auth
+
+import (
+	"os"
+	"io/ioutil"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"strings"
+	"crypto/x509"
+	"./config"
+	"./logger"
+)
+
+// Log of the auth subsystem.
+var log = logger.New("auth")
+
+// Level is the permission level. Every level includes all lower levels.
+type Level int
+
+// Permission levels.
+const (
+	// Only authentication and connection related commands are allowed.
+	LevelNone Level = iota
+	// Browsing library, playlists and player status.
+	LevelRead
+	// Player control: play, pause, seek, etc.
+	LevelControl
+	// Playlists modification.
+	LevelAdd
+	// Daemon administration.
+	LevelAdmin
+)
+
+// Levels names.
+var levelNames = map[Level]string{
+	LevelNone:    "none",
+	LevelRead:    "read",
+	LevelControl: "control",
+	LevelAdd:     "add",
+	LevelAdmin:   "admin",
+}
+
+// String returns the level name.
+func (level Level) String() string {
+	name, ok := levelNames[level]
+	if !ok {
+		return fmt.Sprintf("level%d", int(level))
+	}
+
+	return name
+}
+
+// ParseLevel returns the level by its name.
+func ParseLevel(name string) (level Level, err os.Error) {
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+
+	return LevelNone, os.NewError(fmt.Sprintf("Unknown permission level '%s'", name))
+}
+
+// DefaultLevel returns the level of unauthenticated connections.
+func DefaultLevel() Level {
+	name, err := config.Configurations.GetString("auth.default")
+	if err != nil {
+		return LevelNone
+	}
+
+	level, err := ParseLevel(name)
+	if err != nil {
+		return LevelNone
+	}
+
+	return level
+}
+
+// Number of incorrect passwords after which the client host is locked out.
+const maxFailures = 5
+
+// Time in nanoseconds the client host is locked out for. Failures older than
+// this are forgotten.
+const lockoutTime = 60e9
+
+// failure is the record of the incorrect passwords sent from the host.
+type failure struct {
+	// Number of incorrect passwords.
+	count int
+	// Time of the last incorrect password in nanoseconds.
+	last int64
+}
+
+// Incorrect passwords by client host.
+var failures = make(map[string]*failure)
+// Mutex for protecting failures.
+var failuresMutex sync.Mutex
+
+// Authenticate returns the level granted by the password. client is the
+// remote address of the connection, failed attempts are counted for its host.
+func Authenticate(password string, client string) (level Level, err os.Error) {
+	host := clientHost(client)
+	if isLockedOut(host) {
+		return LevelNone, os.NewError("Too many incorrect passwords, try again later")
+	}
+
+	passwords, err := config.Configurations.GetList("auth.passwords")
+	if err != nil {
+		return LevelNone, err
+	}
+
+	for _, entry := range passwords {
+		i := strings.LastIndex(entry, "@")
+		if i == -1 {
+			continue
+		}
+
+		if entry[:i] == password {
+			forgetFailures(host)
+			return ParseLevel(entry[i+1:])
+		}
+	}
+
+	addFailure(host)
+
+	return LevelNone, os.NewError("Incorrect password")
+}
+
+// clientHost returns host of the remote address. Connections from UNIX sockets
+// have no address and share one record.
+func clientHost(client string) string {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		return client
+	}
+
+	return host
+}
+
+// isLockedOut returns true if the host sent too many incorrect passwords
+// recently. Outdated records of all hosts are dropped.
+func isLockedOut(host string) bool {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	now := time.Nanoseconds()
+	for h, f := range failures {
+		if now-f.last > lockoutTime {
+			failures[h] = nil, false
+		}
+	}
+
+	f, ok := failures[host]
+
+	return ok && f.count >= maxFailures
+}
+
+// addFailure counts incorrect password sent from the host.
+func addFailure(host string) {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	f, ok := failures[host]
+	if !ok {
+		f = new(failure)
+		failures[host] = f
+	}
+	f.count++
+	f.last = time.Nanoseconds()
+
+	if f.count == maxFailures {
+		log.Warning("Host '%s' is locked out after %d incorrect passwords", host, f.count)
+	}
+}
+
+// forgetFailures drops incorrect passwords record of the host.
+func forgetFailures(host string) {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	failures[host] = nil, false
+}
+
+// CA certificates from the tls.ca file. nil if client certificates aren't
+// verified. Pool is rebuilt when tls.ca item is changed.
+var caPool *x509.CertPool
+// Mutex for protecting caPool.
+var caMutex sync.RWMutex
+
+// loadCA reads CA certificates from the tls.ca file.
+func loadCA(cfg *config.Config) (pool *x509.CertPool, err os.Error) {
+	caFile, _ := cfg.GetString("tls.ca")
+	if caFile == "" {
+		return nil, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, os.NewError(fmt.Sprintf("No certificates found in '%s'", caFile))
+	}
+
+	return pool, nil
+}
+
+// validateCA checks if the tls.ca file can be loaded.
+func validateCA(cfg *config.Config) os.Error {
+	_, err := loadCA(cfg)
+
+	return err
+}
+
+// updateCA reloads CA certificates.
+func updateCA(cfg *config.Config) {
+	pool, err := loadCA(cfg)
+	if err != nil {
+		log.Error("CA certificates aren't changed. %s", err)
+		return
+	}
+
+	caMutex.Lock()
+	caPool = pool
+	caMutex.Unlock()
+}
+
+// CertificateLevel returns the level granted by the client certificate chain.
+// false is returned if certificate isn't trusted or its subject isn't listed
+// in the tls.clients item.
+func CertificateLevel(certs []*x509.Certificate) (level Level, ok bool) {
+	if len(certs) == 0 {
+		return LevelNone, false
+	}
+
+	caMutex.RLock()
+	roots := caPool
+	caMutex.RUnlock()
+	if roots == nil {
+		return LevelNone, false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	if err != nil {
+		return LevelNone, false
+	}
+
+	clients, err := config.Configurations.GetList("tls.clients")
+	if err != nil {
+		return LevelNone, false
+	}
+
+	for _, entry := range clients {
+		i := strings.LastIndex(entry, "@")
+		if i == -1 {
+			continue
+		}
+
+		if entry[:i] == certs[0].Subject.CommonName {
+			level, err := ParseLevel(entry[i+1:])
+			if err != nil {
+				return LevelNone, false
+			}
+			return level, true
+		}
+	}
+
+	return LevelNone, false
+}
+
+// Package init function.
+func init() {
+	config.Watch("tls.ca", validateCA, updateCA)
+}
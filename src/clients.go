@@ -0,0 +1,169 @@
+// Registry of the connected clients and connection limits.
+package server
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Connection limits. They are shared by all servers.
+var (
+	// Maximal number of connected clients. 0 means no limit.
+	maxClients int
+	// Time in nanoseconds idle client (which doesn't send commands) is disconnected after.
+	// 0 means no timeout.
+	idleTimeout int64
+	// Maximal length of the command line in bytes.
+	maxLineLength = 4096
+	// Time in nanoseconds Shutdown waits for the running commands to be finished.
+	shutdownTimeout int64 = 5e9
+)
+
+// MinLineLength is the smallest maximal line length. Line reader can't
+// use smaller buffer.
+const MinLineLength = 16
+
+// SetMaxClients sets maximal number of connected clients. 0 means no limit.
+// Clients connected over the limit get error greeting and are disconnected.
+func SetMaxClients(n int) {
+	maxClients = n
+}
+
+// SetIdleTimeout sets time in nanoseconds after which idle client is disconnected.
+// Clients waiting for the blocking command (e. g. IDLE) are not idle. 0 means no timeout.
+func SetIdleTimeout(ns int64) {
+	idleTimeout = ns
+}
+
+// SetMaxLineLength sets maximal length of the command line. Client which sends
+// longer line is disconnected. It shouldn't be less than MinLineLength.
+func SetMaxLineLength(n int) {
+	maxLineLength = n
+}
+
+// SetShutdownTimeout sets time in nanoseconds Shutdown waits for the running
+// commands to be finished.
+func SetShutdownTimeout(ns int64) {
+	shutdownTimeout = ns
+}
+
+// ClientInfo describes connected client.
+type ClientInfo struct {
+	// Client identifier, it is unique for the daemon run.
+	Id int
+	// Client's network address.
+	RemoteAddr string
+	// Connection time in nanoseconds since the epoch.
+	Connected int64
+	// Last command recieved from the client.
+	LastCommand string
+	// Handler of the client's commands.
+	Handler CommandHandler
+}
+
+// client is the registry entry.
+type client struct {
+	info ClientInfo
+	conn net.Conn
+}
+
+// All connected clients.
+var clients = make(map[int]*client)
+// Identifier of the last connected client.
+var lastClientId int
+// Mutex for protecting clients and lastClientId.
+var clientsMutex sync.Mutex
+
+// addClient registers new client connection. nil is returned if there are
+// too many clients already. Limit is checked and the client is registered
+// at once, so concurrent connections can't exceed the limit.
+func addClient(conn net.Conn, handler CommandHandler) *client {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	if maxClients > 0 && len(clients) >= maxClients {
+		return nil
+	}
+
+	lastClientId++
+
+	c := new(client)
+	c.conn = conn
+	c.info.Id = lastClientId
+	c.info.RemoteAddr = conn.RemoteAddr().String()
+	c.info.Connected = time.Nanoseconds()
+	c.info.Handler = handler
+	clients[c.info.Id] = c
+	log.Info("Client %d connected from %s", c.info.Id, c.info.RemoteAddr)
+
+	return c
+}
+
+// removeClient unregisters client connection.
+func removeClient(c *client) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	clients[c.info.Id] = nil, false
+	log.Info("Client %d disconnected", c.info.Id)
+}
+
+// setLastCommand remembers last command recieved from the client.
+func (c *client) setLastCommand(command string) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	c.info.LastCommand = command
+}
+
+// Clients returns list of the connected clients ordered by identifier.
+func Clients() []*ClientInfo {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	infos := make([]*ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		info := c.info
+		infos = append(infos, &info)
+	}
+	sort.Sort(clientInfoArray(infos))
+
+	return infos
+}
+
+// clientInfoArray is helper type for sorting clients by identifier.
+type clientInfoArray []*ClientInfo
+
+// Len returns length of the array.
+func (ca clientInfoArray) Len() int {
+	return len(ca)
+}
+
+// Less returns true if i-element of the array less than j-element.
+func (ca clientInfoArray) Less(i int, j int) bool {
+	return ca[i].Id < ca[j].Id
+}
+
+// Swap swaps two array elements.
+func (ca clientInfoArray) Swap(i int, j int) {
+	ca[i], ca[j] = ca[j], ca[i]
+}
+
+// Kick disconnects the client. Running command of the client is canceled.
+func Kick(id int) os.Error {
+	clientsMutex.Lock()
+	c, ok := clients[id]
+	clientsMutex.Unlock()
+
+	if !ok {
+		return os.NewError(fmt.Sprintf("Client %d not found", id))
+	}
+
+	log.Info("Client %d is kicked", id)
+
+	return c.conn.Close()
+}
@@ -7,54 +7,171 @@ package config
 import (
 	"os"
 	"fmt"
+	"sync"
 )
 
 // Configuration parameters parsed from config file and command line parameters.
 var Configurations Config
 
+// DefaultFilename is the configuration file used if other one isn't given
+// on the command line.
+const DefaultFilename = "/etc/chubd.conf"
+
 // Possible types of the config item value.
 const (
 	typeString = iota
-	typeInt    = iota
+	typeInt
+	// true or false (yes/no, on/off and 1/0 are accepted too).
+	typeBool
+	// Nanoseconds. Written as number with the unit suffix: 500ms, 10s, 5m, 1h.
+	// Number without suffix is seconds.
+	typeDuration
+	// Comma separated list of strings.
+	typeList
 )
 
+// Types names for the error messages.
+var typeNames = map[int]string{
+	typeString:   "a string",
+	typeInt:      "an integer",
+	typeBool:     "a boolean",
+	typeDuration: "a duration",
+	typeList:     "a list",
+}
+
 // item is the one configuration item representation struct.
 type item struct {
 	t     int
 	value interface{}
 }
 
-// Map with default values.
+// Map with default values. Keys of the file sections are prefixed with
+// the section name: key "max" of the section [clients] is "clients.max".
 var defaults = map[string]item{
+	// Music library directory. It is ignored if fs.mounts is defined.
 	"fs.root": item{typeString, "/"},
+	// List of name=path pairs: physical directories mounted under the top-level
+	// VFS directories, e. g. "local=/srv/music, nas=/mnt/nas/music".
+	"fs.mounts": item{typeList, []string{}},
+	// Names of the mounts which files are opened for reading only.
+	"fs.readonly": item{typeList, []string{}},
+	// Names of the mounts which are not listed in the VFS root.
+	"fs.hidden": item{typeList, []string{}},
+	// Addresses protocol server listens:
+	// host:port, [ipv6]:port, tls:host:port or unix:/path/to/socket.
+	"listen": item{typeList, []string{"127.0.0.1:8888"}},
+	// Address for the HTTP/REST gateway, e. g. 127.0.0.1:8080.
+	// Gateway is disabled if empty.
+	"http.listen": item{typeString, ""},
+	// Commands which can be executed with POST /commands/NAME requests of
+	// the HTTP gateway. Commands of the fixed routes are always available.
+	"http.commands": item{typeList, []string{"PING", "PWD", "LS", "STATUS", "PLAYLISTS",
+		"PLAYLIST", "COMMANDS", "HELP"}},
+	// Address for the WebSocket endpoint, e. g. 127.0.0.1:8081.
+	// Endpoint is disabled if empty.
+	"websocket.listen": item{typeString, ""},
+	// HTTP path WebSocket connections are accepted on.
+	"websocket.path": item{typeString, "/ws"},
+	// Origins of the web pages which can connect to the WebSocket endpoint,
+	// e. g. "http://player.local:8080". Pages served from the endpoint host
+	// are always allowed.
+	"websocket.origins": item{typeList, []string{}},
+	// Permission level of the connections which didn't send a password:
+	// none, read, control, add or admin. Raise it (e. g. to control) only
+	// if everybody who can connect is trusted, otherwise use auth.passwords.
+	"auth.default": item{typeString, "read"},
+	// List of password@level pairs, e. g. "secret@admin, guest@read".
+	"auth.passwords": item{typeList, []string{}},
+	// Address for the MPD protocol frontend, e. g. 127.0.0.1:6600 or unix:/path.
+	// Frontend is disabled if empty.
+	"mpd.listen": item{typeString, ""},
+	// File playlists and playing position are saved to on shutdown and restored
+	// from on start. Set it to a file in the home directory if daemon runs as
+	// a user which can't write to /var/lib/chubd. State isn't saved if empty.
+	"player.state": item{typeString, "/var/lib/chubd/state"},
+	// Audio output driver.
+	"audio.output": item{typeString, "alsa"},
+	// Octal permissions of the UNIX socket file.
+	"unix.mode": item{typeString, "0660"},
+	// Maximal number of connected clients. 0 means no limit.
+	"clients.max": item{typeInt, 0},
+	// Idle clients are disconnected after this time. 0 means no timeout.
+	"clients.timeout": item{typeDuration, int64(0)},
+	// Maximal length of the command line in bytes.
+	"clients.max_line": item{typeInt, 4096},
+	// Time running commands are waited for on shutdown.
+	"clients.shutdown_timeout": item{typeDuration, int64(5e9)},
+	// PEM encoded server certificate and private key files. TLS listeners and
+	// STARTTLS are available only if both are set.
+	"tls.cert": item{typeString, ""},
+	"tls.key":  item{typeString, ""},
+	// PEM encoded CA certificates file client certificates are verified with.
+	// Client certificates aren't requested if empty.
+	"tls.ca": item{typeString, ""},
+	// List of common_name@level pairs, which grant permission levels
+	// to the clients with trusted certificates, e. g. "laptop@admin".
+	"tls.clients": item{typeList, []string{}},
+	// Log destination: stderr, syslog or the file name. stderr is replaced
+	// with syslog if daemon is detached from the terminal.
+	"log.output": item{typeString, "stderr"},
+	// Log level: error, warning, info or debug.
+	"log.level": item{typeString, "info"},
 }
 
 // Config represents configuration file.
 type Config struct {
 	items map[string]item
+	// Mutex for protecting items.
+	mutex sync.RWMutex
+}
+
+// newConfig returns configuration filled with default values.
+func newConfig() *Config {
+	config := new(Config)
+	config.items = make(map[string]item, len(defaults))
+	for key, i := range defaults {
+		config.items[key] = i
+	}
+
+	return config
+}
+
+// lookup returns the item of the given type. Caller should hold the mutex.
+func (config *Config) lookup(key string, t int) (i item, err os.Error) {
+	i, present := config.items[key]
+	if !present {
+		return i, os.NewError(fmt.Sprintf("Key '%s' not found", key))
+	}
+	if i.t != t {
+		return i, os.NewError(fmt.Sprintf("Key '%s' associated with not %s value", key, typeNames[t]))
+	}
+
+	return i, nil
 }
 
-// Parse parses configuration file and returns its representation.
-func Parse(filename string) (config *Config, err os.Error) {
-	config = new(Config)
-	config.items = defaults
+// update sets new value of the item of the given type.
+func (config *Config) update(key string, t int, value interface{}) os.Error {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
 
-	// TODO: Parse file and fill the map.
+	_, err := config.lookup(key, t)
+	if err != nil {
+		return err
+	}
 
-	root := config.items["fs.root"]
-	root.value = "foo"
+	config.items[key] = item{t, value}
 
-	return config, nil
+	return nil
 }
 
 // GetString returns string value for the given key.
 func (config *Config) GetString(key string) (value string, err os.Error) {
-	i, present := config.items[key]
-	if !present {
-		return "", os.NewError(fmt.Sprintf("Key '%s' not found", key))
-	}
-	if i.t != typeString {
-		return "", os.NewError(fmt.Sprintf("Key '%s' associated with not a string value", key))
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	i, err := config.lookup(key, typeString)
+	if err != nil {
+		return "", err
 	}
 
 	return i.value.(string), nil
@@ -62,28 +179,103 @@ func (config *Config) GetString(key string) (value string, err os.Error) {
 
 // SetString sets new value for the configuration item.
 func (config *Config) SetString(key string, value string) os.Error {
-	i, present := config.items[key]
-	if !present {
-		return os.NewError(fmt.Sprintf("Key '%s' not found", key))
+	return config.update(key, typeString, value)
+}
+
+// GetInt returns integer value for the given key.
+func (config *Config) GetInt(key string) (value int, err os.Error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	i, err := config.lookup(key, typeInt)
+	if err != nil {
+		return 0, err
 	}
-	if i.t != typeString {
-		return os.NewError(fmt.Sprintf("Key '%s' associated with not a string value", key))
+
+	return i.value.(int), nil
+}
+
+// SetInt sets new value for the configuration item.
+func (config *Config) SetInt(key string, value int) os.Error {
+	return config.update(key, typeInt, value)
+}
+
+// GetBool returns boolean value for the given key.
+func (config *Config) GetBool(key string) (value bool, err os.Error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	i, err := config.lookup(key, typeBool)
+	if err != nil {
+		return false, err
 	}
 
-	config.items[key] = item{i.t, value}
+	return i.value.(bool), nil
+}
 
-	return nil
+// SetBool sets new value for the configuration item.
+func (config *Config) SetBool(key string, value bool) os.Error {
+	return config.update(key, typeBool, value)
 }
 
-// File initialization function.
-func init() {
-	filename := "/etc/chubd.conf"
+// GetDuration returns duration value in nanoseconds for the given key.
+func (config *Config) GetDuration(key string) (value int64, err os.Error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	i, err := config.lookup(key, typeDuration)
+	if err != nil {
+		return 0, err
+	}
+
+	return i.value.(int64), nil
+}
+
+// SetDuration sets new value (in nanoseconds) for the configuration item.
+func (config *Config) SetDuration(key string, value int64) os.Error {
+	return config.update(key, typeDuration, value)
+}
+
+// GetList returns list value for the given key.
+func (config *Config) GetList(key string) (value []string, err os.Error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
 
-	cfg, err := Parse(filename)
+	i, err := config.lookup(key, typeList)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to parse configuration file '%s'. %s", filename, err))
+		return nil, err
 	}
 
-	Configurations = *cfg
-	Configurations.SetString("fs.root", "/home/viacheslav/projects/chubd_music")
+	return i.value.([]string), nil
+}
+
+// SetList sets new value for the configuration item.
+func (config *Config) SetList(key string, value []string) os.Error {
+	return config.update(key, typeList, value)
+}
+
+// Set parses value according to the item type and sets it.
+func (config *Config) Set(key string, value string) os.Error {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+
+	i, present := config.items[key]
+	if !present {
+		return os.NewError(fmt.Sprintf("Unknown key '%s'", key))
+	}
+
+	v, err := parseValue(i.t, value)
+	if err != nil {
+		return os.NewError(fmt.Sprintf("Bad value of '%s'. %s", key, err))
+	}
+
+	config.items[key] = item{i.t, v}
+
+	return nil
+}
+
+// File initialization function. Default values are available
+// till configuration file is loaded.
+func init() {
+	Configurations.items = newConfig().items
 }
@@ -0,0 +1,157 @@
+// Configuration file parser.
+//
+// File consists of "key = value" lines. Lines starting with # or ; are
+// comments. "[section]" line starts the section: keys below it are prefixed
+// with the section name and dot. Value can be enclosed in double quotes
+// to keep leading and trailing spaces.
+//
+//   listen = 127.0.0.1:8888, unix:/var/run/chubd.sock
+//
+//   [clients]
+//   # Disconnect idle clients.
+//   timeout = 10m
+package config
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+)
+
+// Parse parses configuration file and returns its representation.
+// Items missing in the file have default values. Unknown keys and bad
+// values are reported with the line number.
+func Parse(filename string) (config *Config, err os.Error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config = newConfig()
+	section := ""
+	reader := bufio.NewReader(file)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != os.EOF {
+			return nil, err
+		}
+		if err == os.EOF && line == "" {
+			break
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			// Skip empty lines and comments.
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, lineError(lineNum, "Section name should be enclosed in []")
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		default:
+			i := strings.Index(line, "=")
+			if i == -1 {
+				return nil, lineError(lineNum, "'key = value' expected")
+			}
+			key := strings.TrimSpace(line[:i])
+			if section != "" {
+				key = section + "." + key
+			}
+
+			err = config.Set(key, unquote(strings.TrimSpace(line[i+1:])))
+			if err != nil {
+				return nil, lineError(lineNum, "%s", err)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// lineError returns error which refers to the configuration file line.
+func lineError(lineNum int, format string, args ...interface{}) os.Error {
+	return os.NewError(fmt.Sprintf("Line %d: %s", lineNum, fmt.Sprintf(format, args...)))
+}
+
+// unquote removes double quotes around the value.
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// parseValue converts string to the value of the given type.
+func parseValue(t int, value string) (v interface{}, err os.Error) {
+	switch t {
+	case typeInt:
+		return strconv.Atoi(value)
+	case typeBool:
+		return parseBool(value)
+	case typeDuration:
+		return parseDuration(value)
+	case typeList:
+		return parseList(value), nil
+	}
+
+	return value, nil
+}
+
+// parseBool converts true/false, yes/no, on/off or 1/0 to boolean.
+func parseBool(value string) (bool, os.Error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+
+	return false, os.NewError(fmt.Sprintf("'%s' is not a boolean", value))
+}
+
+// Duration units suffixes. Longer suffixes go first, so "ms" isn't taken for "s".
+var durationUnits = []struct {
+	suffix string
+	ns     int64
+}{
+	{"ms", 1e6},
+	{"s", 1e9},
+	{"m", 60e9},
+	{"h", 3600e9},
+}
+
+// parseDuration converts duration with the unit suffix (ms, s, m, h)
+// to nanoseconds. Number without suffix is seconds.
+func parseDuration(value string) (int64, os.Error) {
+	number, unit := value, int64(1e9)
+	for _, u := range durationUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			number, unit = strings.TrimSpace(value[:len(value)-len(u.suffix)]), u.ns
+			break
+		}
+	}
+
+	n, err := strconv.Atoi64(number)
+	if err != nil || n < 0 {
+		return 0, os.NewError(fmt.Sprintf("'%s' is not a duration", value))
+	}
+
+	return n * unit, nil
+}
+
+// parseList splits comma separated list. Empty elements are dropped.
+func parseList(value string) []string {
+	list := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+
+	return list
+}
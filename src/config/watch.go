@@ -0,0 +1,216 @@
+// Runtime configuration changes.
+//
+// Subsystems register watchers for the items they depend on. When configuration
+// is changed (by CONFIG SET command or file reload) all watchers of the changed
+// items validate new configuration first. If any of them rejects it nothing is
+// changed, otherwise new configuration becomes current and watchers apply it.
+// Items without watchers are read by subsystems when they are needed or take
+// effect after restart (e. g. listen addresses).
+package config
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"strings"
+	"strconv"
+)
+
+// Validator checks new configuration before it becomes current.
+type Validator func(config *Config) os.Error
+
+// Applier is called after configuration has been changed.
+type Applier func(config *Config)
+
+// watcher is the registered pair of the item callbacks.
+type watcher struct {
+	validate Validator
+	apply    Applier
+}
+
+// Items which can be changed in the configuration file only. Daemon writes
+// files they name, so clients could overwrite any file daemon can write.
+var fileOnlyKeys = map[string]bool{
+	"log.output":   true,
+	"player.state": true,
+}
+
+// Items which values are secrets and shouldn't be shown to clients.
+var secretKeys = map[string]bool{
+	"auth.passwords": true,
+}
+
+// Registered watchers by item key.
+var watchers = make(map[string][]watcher)
+// Mutex for serializing configuration changes and protecting watchers.
+var changeMutex sync.Mutex
+
+// Watch registers callbacks for the configuration item. validate (can be nil)
+// is called with new configuration before it becomes current and can reject
+// the change. apply is called with current configuration after the item
+// has been changed.
+func Watch(key string, validate Validator, apply Applier) {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+
+	watchers[key] = append(watchers[key], watcher{validate, apply})
+}
+
+// Load parses configuration file and makes it the current configuration.
+// overrides (e. g. from the command line) take precedence over the file items.
+func Load(filename string, overrides map[string]string) os.Error {
+	cfg, err := Parse(filename)
+	if err != nil {
+		return os.NewError(fmt.Sprintf("Failed to parse configuration file '%s'. %s", filename, err))
+	}
+
+	return loadWithOverrides(cfg, overrides)
+}
+
+// LoadDefaults makes the default configuration with overrides applied the
+// current configuration. It is used when there is no configuration file.
+func LoadDefaults(overrides map[string]string) os.Error {
+	return loadWithOverrides(newConfig(), overrides)
+}
+
+// loadWithOverrides applies overrides to the configuration and makes it current.
+// File only items can be overridden too.
+func loadWithOverrides(cfg *Config, overrides map[string]string) os.Error {
+	for key, value := range overrides {
+		err := cfg.Set(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+
+	return commit(cfg)
+}
+
+// Change sets the value of the current configuration item. Items which can be
+// set in the configuration file only are rejected.
+func Change(key string, value string) os.Error {
+	if fileOnlyKeys[key] {
+		return os.NewError(fmt.Sprintf("'%s' can be changed in the configuration file only", key))
+	}
+
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+
+	cfg := Configurations.clone()
+	err := cfg.Set(key, value)
+	if err != nil {
+		return err
+	}
+
+	return commit(cfg)
+}
+
+// commit makes configuration current if watchers of the changed items accept it.
+// Caller should hold changeMutex.
+func commit(cfg *Config) os.Error {
+	changed := make([]string, 0)
+	for _, key := range Configurations.Keys() {
+		old, _ := Configurations.String(key)
+		value, _ := cfg.String(key)
+		if old != value {
+			changed = append(changed, key)
+		}
+	}
+
+	for _, key := range changed {
+		for _, w := range watchers[key] {
+			if w.validate == nil {
+				continue
+			}
+			err := w.validate(cfg)
+			if err != nil {
+				return os.NewError(fmt.Sprintf("Bad value of '%s'. %s", key, err))
+			}
+		}
+	}
+
+	Configurations.mutex.Lock()
+	Configurations.items = cfg.items
+	Configurations.mutex.Unlock()
+
+	for _, key := range changed {
+		for _, w := range watchers[key] {
+			w.apply(&Configurations)
+		}
+	}
+
+	return nil
+}
+
+// IsSecret returns true if the item value shouldn't be shown to clients.
+func IsSecret(key string) bool {
+	return secretKeys[key]
+}
+
+// clone returns copy of the configuration.
+func (config *Config) clone() *Config {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	cfg := new(Config)
+	cfg.items = make(map[string]item, len(config.items))
+	for key, i := range config.items {
+		cfg.items[key] = i
+	}
+
+	return cfg
+}
+
+// Keys returns sorted list of all configuration items keys.
+func (config *Config) Keys() []string {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	keys := make([]string, 0, len(config.items))
+	for key := range config.items {
+		keys = append(keys, key)
+	}
+	sort.SortStrings(keys)
+
+	return keys
+}
+
+// String returns the value of any type in the configuration file format.
+func (config *Config) String(key string) (value string, err os.Error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	i, present := config.items[key]
+	if !present {
+		return "", os.NewError(fmt.Sprintf("Unknown key '%s'", key))
+	}
+
+	switch i.t {
+	case typeInt:
+		return strconv.Itoa(i.value.(int)), nil
+	case typeBool:
+		return strconv.Btoa(i.value.(bool)), nil
+	case typeDuration:
+		return formatDuration(i.value.(int64)), nil
+	case typeList:
+		return strings.Join(i.value.([]string), ", "), nil
+	}
+
+	return i.value.(string), nil
+}
+
+// formatDuration returns duration with the largest unit it can be expressed in.
+func formatDuration(ns int64) string {
+	for i := len(durationUnits) - 1; i >= 0; i-- {
+		u := durationUnits[i]
+		if ns != 0 && ns%u.ns == 0 {
+			return fmt.Sprintf("%d%s", ns/u.ns, u.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%dms", ns/1e6)
+}
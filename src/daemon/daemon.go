@@ -0,0 +1,213 @@
+// Daemon package implements detaching of the process from the terminal
+// and pidfile handling.
+//
+// Go runtime can't fork safely, so the classic double fork is done by
+// re-executing the binary with the same arguments. Stage of the
+// daemonization is passed to the child in the environment variable.
+//
+// Process started from the terminal doesn't exit till the daemon reports
+// that it is ready (or failed to start) over the pipe, so its exit code
+// tells if the daemon is running.
+package daemon
+
+import (
+	"os"
+	"exec"
+	"strings"
+	"syscall"
+	"io/ioutil"
+)
+
+// Environment variable which holds daemonization stage of the process.
+const stageVariable = "CHUBD_DAEMON_STAGE"
+
+// Daemonization stages.
+const (
+	// Process started from the terminal.
+	stageParent = ""
+	// Session leader detached from the terminal.
+	stageSessionLeader = "1"
+	// Daemon itself. It isn't session leader, so it can't acquire
+	// controlling terminal anymore.
+	stageDaemon = "2"
+)
+
+// Descriptor of the readiness pipe in the session leader and daemon processes.
+const readyFd = 3
+
+// Readiness messages.
+const (
+	readyOk    = "OK"
+	readyError = "ERROR "
+)
+
+// Write end of the readiness pipe in the daemon process. nil if process
+// isn't daemonized or readiness was reported already.
+var readyPipe *os.File
+
+// Daemonize detaches the process from the terminal. It returns in the daemon
+// process only, intermediate processes exit. Process started from the terminal
+// exits when the daemon calls Ready, or returns error if daemon calls Failed
+// or exits before it is ready. Standard input and output of the daemon are
+// redirected to /dev/null. Working directory isn't changed, so relative paths
+// of the command line still work.
+func Daemonize() os.Error {
+	switch os.Getenv(stageVariable) {
+	case stageParent:
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		err = respawn(stageSessionLeader, true, writer)
+		writer.Close()
+		if err != nil {
+			reader.Close()
+			return err
+		}
+
+		err = waitReady(reader)
+		if err != nil {
+			return err
+		}
+		os.Exit(0)
+	case stageSessionLeader:
+		ready := os.NewFile(readyFd, "ready")
+		err := respawn(stageDaemon, false, ready)
+		if err != nil {
+			ready.WriteString(readyError + err.String() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case stageDaemon:
+		readyPipe = os.NewFile(readyFd, "ready")
+	}
+
+	return nil
+}
+
+// Ready lets the process started from the terminal know that daemon
+// is started. It does nothing if process isn't daemonized.
+func Ready() {
+	report(readyOk)
+}
+
+// Failed lets the process started from the terminal know that daemon
+// failed to start. It does nothing if process isn't daemonized.
+func Failed(message string) {
+	report(readyError + message)
+}
+
+// report sends readiness message and closes the pipe.
+func report(message string) {
+	if readyPipe == nil {
+		return
+	}
+
+	readyPipe.WriteString(message + "\n")
+	readyPipe.Close()
+	readyPipe = nil
+}
+
+// waitReady waits for the daemon readiness message. Pipe is closed without
+// message if daemon exited before it was ready.
+func waitReady(reader *os.File) os.Error {
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	message := strings.TrimSpace(string(data))
+	switch {
+	case message == readyOk:
+		return nil
+	case strings.HasPrefix(message, readyError):
+		return os.NewError(message[len(readyError):])
+	}
+
+	return os.NewError("Daemon exited before it was started")
+}
+
+// respawn starts the copy of the process for the next daemonization stage.
+// setsid is true if child should become session leader. ready is passed
+// to the child as the readyFd descriptor.
+func respawn(stage string, setsid bool, ready *os.File) os.Error {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+
+	null, err := os.OpenFile("/dev/null", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+
+	env := make([]string, 0)
+	for _, v := range os.Environ() {
+		if len(v) <= len(stageVariable) || v[:len(stageVariable)+1] != stageVariable+"=" {
+			env = append(env, v)
+		}
+	}
+	env = append(env, stageVariable+"="+stage)
+
+	attr := &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{null, null, null, ready},
+		Sys:   &syscall.SysProcAttr{Setsid: setsid},
+	}
+	_, err = os.StartProcess(path, os.Args, attr)
+
+	return err
+}
+
+// Copies of the original standard output and standard error descriptors.
+// -1 if output wasn't redirected.
+var savedFds = []int{-1, -1}
+
+// RedirectOutput redirects standard output and standard error (including
+// panic messages) to the file. Output is appended to the file.
+func RedirectOutput(filename string) os.Error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for i, fd := range []int{syscall.Stdout, syscall.Stderr} {
+		if savedFds[i] == -1 {
+			saved, errno := syscall.Dup(fd)
+			if errno != 0 {
+				return os.NewSyscallError("dup", errno)
+			}
+			savedFds[i] = saved
+		}
+
+		errno := syscall.Dup2(file.Fd(), fd)
+		if errno != 0 {
+			return os.NewSyscallError("dup2", errno)
+		}
+	}
+
+	return nil
+}
+
+// RestoreOutput points standard output and standard error back to
+// the descriptors they had before RedirectOutput.
+func RestoreOutput() os.Error {
+	for i, fd := range []int{syscall.Stdout, syscall.Stderr} {
+		if savedFds[i] == -1 {
+			continue
+		}
+
+		errno := syscall.Dup2(savedFds[i], fd)
+		if errno != 0 {
+			return os.NewSyscallError("dup2", errno)
+		}
+		syscall.Close(savedFds[i])
+		savedFds[i] = -1
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+// Pidfile handling.
+package daemon
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"io/ioutil"
+	"syscall"
+)
+
+// Pidfile is the locked file which holds process identifier of the running
+// daemon. Lock is held till the process exits, so stale pidfile left by the
+// crashed daemon doesn't prevent start of the new one.
+type Pidfile struct {
+	file *os.File
+	path string
+}
+
+// CreatePidfile creates (or reuses stale) pidfile, locks it and writes
+// identifier of the current process. Error is returned if pidfile is locked
+// by another running instance.
+func CreatePidfile(path string) (pidfile *Pidfile, err os.Error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	errno := syscall.Flock(file.Fd(), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errno != 0 {
+		file.Close()
+		return nil, alreadyRunning(path)
+	}
+
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteString(fmt.Sprintf("%d\n", os.Getpid()))
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Pidfile{file, path}, nil
+}
+
+// CheckPidfile returns error if pidfile is locked by another running instance.
+// Pidfile itself isn't changed.
+func CheckPidfile(path string) os.Error {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil // No pidfile, nobody is running.
+	}
+	defer file.Close()
+
+	errno := syscall.Flock(file.Fd(), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errno != 0 {
+		return alreadyRunning(path)
+	}
+
+	return nil
+}
+
+// Remove removes pidfile and releases the lock.
+func (pidfile *Pidfile) Remove() {
+	os.Remove(pidfile.path)
+	pidfile.file.Close()
+}
+
+// alreadyRunning returns error which describes instance holding the pidfile.
+func alreadyRunning(path string) os.Error {
+	pid, err := ioutil.ReadFile(path)
+	if err != nil {
+		return os.NewError(fmt.Sprintf("Another instance is running ('%s' is locked)", path))
+	}
+
+	return os.NewError(fmt.Sprintf("Another instance is running (pid %s, '%s' is locked)",
+		strings.TrimSpace(string(pid)), path))
+}
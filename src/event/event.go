@@ -0,0 +1,134 @@
+// Event package implements notification bus which lets clients know about
+// changes in the daemon subsystems (player, playlists, etc.)
+package event
+
+import (
+	"sync"
+)
+
+// Subsystems names.
+const (
+	// Player state (stopped, playing, paused) or position was changed.
+	Player = "player"
+	// Current track was changed.
+	Track = "track"
+	// Playlist was created or deleted.
+	Playlists = "playlists"
+	// Playlist content was modified.
+	Playlist = "playlist"
+	// Music library was updated.
+	Library = "library"
+)
+
+// Subsystems is the list of all supported subsystems.
+var Subsystems = []string{Player, Track, Playlists, Playlist, Library}
+
+// Listener collects names of the changed subsystems till they are retrieved with Wait.
+type Listener struct {
+	// Set of subsystems changed since the last Wait call.
+	changed map[string]bool
+	// Channel is signaled every time new change is registered.
+	notify chan bool
+	// Mutex for protecting changed field.
+	mutex sync.Mutex
+}
+
+// All registered listeners.
+var listeners = make(map[*Listener]bool)
+
+// Mutex for protecting listeners.
+var mutex sync.Mutex
+
+// IsSubsystem returns true if name is a supported subsystem name.
+func IsSubsystem(name string) bool {
+	for _, s := range Subsystems {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscribe returns new listener which will collect all changes since now.
+func Subscribe() *Listener {
+	l := new(Listener)
+	l.changed = make(map[string]bool)
+	l.notify = make(chan bool, 1)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	listeners[l] = true
+
+	return l
+}
+
+// Unsubscribe stops collecting changes by the listener.
+func (l *Listener) Unsubscribe() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	listeners[l] = false, false
+}
+
+// Notify lets all listeners know that given subsystem was changed.
+func Notify(subsystem string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for l, _ := range listeners {
+		l.add(subsystem)
+	}
+}
+
+// add registers change of the subsystem.
+func (l *Listener) add(subsystem string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.changed[subsystem] = true
+
+	// Wake up waiter if it isn't woken up yet.
+	select {
+	case l.notify <- true:
+	default:
+	}
+}
+
+// take returns changed subsystems from the given list and forgets about them.
+func (l *Listener) take(subsystems []string) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	changed := make([]string, 0)
+	for _, s := range subsystems {
+		if l.changed[s] {
+			changed = append(changed, s)
+			l.changed[s] = false, false
+		}
+	}
+
+	return changed
+}
+
+// Wait blocks till any of the given subsystems is changed and returns changed ones.
+// If some of subsystems were changed since the last call Wait returns immediately.
+// Waiting can be canceled by sending a value to cancel channel, nil is returned in this case.
+func (l *Listener) Wait(subsystems []string, cancel <-chan bool) []string {
+	for {
+		changed := l.take(subsystems)
+		if len(changed) > 0 {
+			return changed
+		}
+
+		select {
+		case <-l.notify:
+			// Check changes again.
+		case <-cancel:
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+// Logger package implements daemon log. Every message has the level and
+// the tag of the subsystem which wrote it (server, protocol, vfs, player, etc.)
+// Messages less important than the current level are dropped. The level can be
+// set for all subsystems or overridden for the single one.
+//
+// Log is written to stderr (default), to the file or to syslog. Daemon detached
+// from the terminal writes to syslog instead of stderr.
+package logger
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"syslog"
+)
+
+// Level is the importance of the message.
+type Level int
+
+// Message levels. Every level includes all more important levels.
+const (
+	// Failures which need administrator's attention.
+	LevelError Level = iota
+	// Problems daemon can work around, e. g. broken file was skipped.
+	LevelWarning
+	// Important events: start, shutdown, client connections.
+	LevelInfo
+	// Detailed information for debugging.
+	LevelDebug
+)
+
+// Levels names.
+var levelNames = map[Level]string{
+	LevelError:   "error",
+	LevelWarning: "warning",
+	LevelInfo:    "info",
+	LevelDebug:   "debug",
+}
+
+// String returns the level name.
+func (level Level) String() string {
+	name, ok := levelNames[level]
+	if !ok {
+		return fmt.Sprintf("level%d", int(level))
+	}
+
+	return name
+}
+
+// ParseLevel returns the level by its name.
+func ParseLevel(name string) (level Level, err os.Error) {
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+
+	return LevelError, os.NewError(fmt.Sprintf("Unknown log level '%s'", name))
+}
+
+// Output destinations which are not files.
+const (
+	OutputStderr = "stderr"
+	OutputSyslog = "syslog"
+)
+
+// Tags of all created loggers.
+var tags = make(map[string]bool)
+// Current level of all subsystems.
+var level = LevelInfo
+// Levels of the subsystems which override the current level.
+var tagLevels = make(map[string]Level)
+// Output for stderr and file destinations.
+var writer io.Writer = os.Stderr
+// Opened log file, nil if log isn't written to the file.
+var file *os.File
+// Output for syslog destination, nil if log isn't written to syslog.
+var sysWriter *syslog.Writer
+// Mutex for protecting all above.
+var mutex sync.Mutex
+
+// SetLevel sets level of all subsystems. Subsystems overrides are reset.
+func SetLevel(l Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	level = l
+	tagLevels = make(map[string]Level)
+}
+
+// SetTagLevel sets level of the single subsystem.
+func SetTagLevel(tag string, l Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tagLevels[tag] = l
+}
+
+// GetLevel returns level of all subsystems.
+func GetLevel() Level {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return level
+}
+
+// TagLevels returns levels of the subsystems which override the level
+// of all subsystems.
+func TagLevels() map[string]Level {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	levels := make(map[string]Level, len(tagLevels))
+	for tag, l := range tagLevels {
+		levels[tag] = l
+	}
+
+	return levels
+}
+
+// SetOutput sets log destination: "stderr", "syslog" or the file name.
+// Messages are appended to the file.
+func SetOutput(destination string) os.Error {
+	var newWriter io.Writer
+	var newFile *os.File
+	var newSysWriter *syslog.Writer
+	var err os.Error
+
+	switch destination {
+	case OutputStderr:
+		newWriter = os.Stderr
+	case OutputSyslog:
+		newSysWriter, err = syslog.New(syslog.LOG_INFO, "chubd")
+	default:
+		newFile, err = os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		newWriter = newFile
+	}
+	if err != nil {
+		return os.NewError(fmt.Sprintf("Can't open log '%s'. %s", destination, err))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file != nil {
+		file.Close()
+	}
+	writer, file, sysWriter = newWriter, newFile, newSysWriter
+
+	return nil
+}
+
+// Logger writes messages of the single subsystem.
+type Logger struct {
+	tag string
+}
+
+// New returns logger for the subsystem with the given tag.
+func New(tag string) *Logger {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tags[tag] = true
+
+	return &Logger{tag}
+}
+
+// IsTag returns true if there is the subsystem with the given tag.
+func IsTag(tag string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return tags[tag]
+}
+
+// Error writes error message.
+func (logger *Logger) Error(format string, args ...interface{}) {
+	logger.write(LevelError, format, args...)
+}
+
+// Warning writes warning message.
+func (logger *Logger) Warning(format string, args ...interface{}) {
+	logger.write(LevelWarning, format, args...)
+}
+
+// Info writes informational message.
+func (logger *Logger) Info(format string, args ...interface{}) {
+	logger.write(LevelInfo, format, args...)
+}
+
+// Debug writes debug message.
+func (logger *Logger) Debug(format string, args ...interface{}) {
+	logger.write(LevelDebug, format, args...)
+}
+
+// write writes the message if it is important enough for the subsystem.
+func (logger *Logger) write(l Level, format string, args ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current, ok := tagLevels[logger.tag]
+	if !ok {
+		current = level
+	}
+	if l > current {
+		return
+	}
+
+	message := fmt.Sprintf("[%s] %s", logger.tag, fmt.Sprintf(format, args...))
+
+	if sysWriter != nil {
+		switch l {
+		case LevelError:
+			sysWriter.Err(message)
+		case LevelWarning:
+			sysWriter.Warning(message)
+		case LevelInfo:
+			sysWriter.Info(message)
+		default:
+			sysWriter.Debug(message)
+		}
+		return
+	}
+
+	fmt.Fprintf(writer, "%s %s %s\n", time.LocalTime().Format("2006-01-02 15:04:05"),
+		strings.ToUpper(l.String()), message)
+}
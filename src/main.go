@@ -3,43 +3,355 @@ package main
 import (
 	"os"
 	"fmt"
+	"net"
+	"flag"
+	"http"
+	"strconv"
 	"os/signal"
+	"crypto/tls"
 	"./server"
 	"./protocol"
+	"./mpd"
+	"./player"
+	"./config"
+	"./daemon"
+	"./logger"
 )
 
+// Log of the daemon itself.
+var log = logger.New("main")
+
 // UNIX signals
 const (
+	SigHup  = 1
+	SigInt  = 2
 	SigTerm = 15
 )
 
-func main() {
-	// TODO: Parse command line parameters.
+// Command line flags.
+var (
+	configFile = flag.String("config", config.DefaultFilename, "configuration file")
+	background = flag.Bool("daemon", false, "detach from the terminal and run in background")
+	pidFile    = flag.String("pidfile", "", "pidfile, second instance with the same pidfile refuses to start")
+	logOutput  = flag.String("log", "", "log destination: stderr (syslog with -daemon), syslog or file name, overrides 'log.output' item")
+	listenAddr = flag.String("listen", "", "comma separated list of addresses to listen, overrides 'listen' item")
+	fsRoot     = flag.String("root", "", "music library directory, overrides 'fs.root' item")
+)
 
-	// TODO: Daemonize itself.
-	//       daemonize()
+// Configuration items set on the command line. They are kept on configuration reload.
+var overrides = make(map[string]string)
 
-	host := "127.0.0.1"
-	port := 8888
+func main() {
+	flag.Parse()
+
+	if *listenAddr != "" {
+		overrides["listen"] = *listenAddr
+	}
+	if *fsRoot != "" {
+		overrides["fs.root"] = *fsRoot
+	}
+	if *logOutput != "" {
+		overrides["log.output"] = *logOutput
+	}
 
-	srv, err := server.NewTCPServer(host, port)
+	err := loadConfig()
 	if err != nil {
-		fmt.Printf("Failed to create server. %s", err)
+		log.Error("%s", err)
 		os.Exit(1)
 	}
 
-	// Run listening loop.
-	srv.SetConnectionHandler(new(protocol.ConnectionHandler))
-	go srv.Serve()
+	if *background {
+		// Report running instance while we still have the terminal.
+		if *pidFile != "" {
+			err = daemon.CheckPidfile(*pidFile)
+			if err != nil {
+				log.Error("Failed to start. %s", err)
+				os.Exit(1)
+			}
+		}
+
+		err = daemon.Daemonize()
+		if err != nil {
+			fail("Failed to daemonize. %s", err)
+		}
+	}
+
+	err = setupLog()
+	if err != nil {
+		fail("%s", err)
+	}
+
+	var pidfile *daemon.Pidfile
+	if *pidFile != "" {
+		pidfile, err = daemon.CreatePidfile(*pidFile)
+		if err != nil {
+			fail("Failed to start. %s", err)
+		}
+	}
+
+	// Restore playlists and playing position saved on the last shutdown.
+	stateFile, _ := config.Configurations.GetString("player.state")
+	if stateFile != "" {
+		err := player.Load(stateFile)
+		if err != nil {
+			log.Warning("Player state isn't loaded from '%s'. %s", stateFile, err)
+		}
+	}
+
+	// Connection limits.
+	setupClients(&config.Configurations)
+	watchConfig()
+
+	// TLS for tls: listeners and STARTTLS. Client certificates are requested
+	// only if there is a CA to verify them with.
+	certFile, _ := config.Configurations.GetString("tls.cert")
+	keyFile, _ := config.Configurations.GetString("tls.key")
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			fail("Failed to load TLS certificate '%s'. %s", certFile, err)
+		}
+		caFile, _ := config.Configurations.GetString("tls.ca")
+		server.SetTLSConfig(&tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			AuthenticateClient: caFile != "",
+		})
+	}
+
+	// All running servers, they are shut down on exit.
+	servers := make([]server.Server, 0)
+
+	unixModeStr, _ := config.Configurations.GetString("unix.mode")
+	unixMode, err := strconv.Btoui64(unixModeStr, 8)
+	if err != nil {
+		fail("Bad UNIX socket permissions '%s'. %s", unixModeStr, err)
+	}
+
+	// Every address of the bind list gets its own listener.
+	listen, _ := config.Configurations.GetList("listen")
+	for _, address := range listen {
+		srv, err := server.Listen(address, uint32(unixMode))
+		if err != nil {
+			fail("Failed to start server. %s", err)
+		}
+
+		// Run listening loop.
+		srv.SetConnectionHandler(new(protocol.ConnectionHandler))
+		go srv.Serve()
+		servers = append(servers, srv)
+	}
+
+	// Optional HTTP/REST gateway.
+	var httpListener net.Listener
+	httpAddr, _ := config.Configurations.GetString("http.listen")
+	if httpAddr != "" {
+		httpListener, err = net.Listen("tcp", httpAddr)
+		if err != nil {
+			fail("Failed to start HTTP gateway on '%s'. %s", httpAddr, err)
+		}
+		go http.Serve(httpListener, protocol.NewRESTHandler())
+	}
+
+	// Optional WebSocket endpoint.
+	wsAddr, _ := config.Configurations.GetString("websocket.listen")
+	if wsAddr != "" {
+		wsPath, _ := config.Configurations.GetString("websocket.path")
+		wsOrigins, _ := config.Configurations.GetList("websocket.origins")
+		wsSrv, err := server.NewWebSocketServer(wsAddr, wsPath, wsOrigins)
+		if err != nil {
+			fail("Failed to start WebSocket endpoint on '%s'. %s", wsAddr, err)
+		}
+		wsSrv.SetConnectionHandler(new(protocol.ConnectionHandler))
+		go wsSrv.Serve()
+		servers = append(servers, wsSrv)
+	}
+
+	// Optional MPD protocol frontend.
+	mpdAddr, _ := config.Configurations.GetString("mpd.listen")
+	if mpdAddr != "" {
+		mpdSrv, err := server.Listen(mpdAddr, uint32(unixMode))
+		if err != nil {
+			fail("Failed to start MPD frontend. %s", err)
+		}
+		mpd.Start()
+		mpdSrv.SetConnectionHandler(new(mpd.ConnectionHandler))
+		go mpdSrv.Serve()
+		servers = append(servers, mpdSrv)
+	}
 
-	// On SIGTERM received we have to close all client connections
-	// and then exit. So we loop till this signal will be recieved.
-	for {
-		sig := (<-signal.Incoming).(os.UnixSignal) // XXX: Will works in windows? No way!
-		sigNum := int32(sig)
+	log.Info("Daemon is started")
+	daemon.Ready()
 
-		if sigNum == SigTerm {
-			break
+	// On SIGTERM (or SIGINT, or KILL command) received we have to close all client
+	// connections and then exit. So we loop till this signal will be recieved.
+	running := true
+	for running {
+		select {
+		case sig := <-signal.Incoming:
+			sigNum := int32(sig.(os.UnixSignal)) // XXX: Will works in windows? No way!
+			if sigNum == SigHup {
+				reloadConfig()
+			}
+			running = sigNum != SigTerm && sigNum != SigInt
+		case <-protocol.KillRequests():
+			running = false
 		}
 	}
+
+	shutdown(servers, httpListener)
+	if pidfile != nil {
+		pidfile.Remove()
+	}
+	os.Exit(0)
+}
+
+// fail logs the error, lets the terminal know that daemon failed to start
+// and exits.
+func fail(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Error("%s", message)
+	daemon.Failed(message)
+	os.Exit(1)
+}
+
+// shutdown closes all client connections, saves player state and stops player.
+func shutdown(servers []server.Server, httpListener net.Listener) {
+	log.Info("Daemon is shutting down")
+
+	if httpListener != nil {
+		httpListener.Close()
+	}
+	for _, srv := range servers {
+		srv.Shutdown()
+	}
+
+	// State is saved before the player is stopped to keep the playing position.
+	// State file could be changed with configuration reload.
+	stateFile, _ := config.Configurations.GetString("player.state")
+	if stateFile != "" {
+		err := player.Save(stateFile)
+		if err != nil {
+			log.Warning("Player state isn't saved to '%s'. %s", stateFile, err)
+		}
+	}
+
+	player.Shutdown()
+}
+
+// setupLog sets log destination and level from the configuration.
+func setupLog() os.Error {
+	output, _ := config.Configurations.GetString("log.output")
+	if output == logger.OutputStderr && *background {
+		// stderr of the daemon is /dev/null.
+		output = logger.OutputSyslog
+	}
+	err := logger.SetOutput(output)
+	if err != nil {
+		return err
+	}
+	if output != logger.OutputStderr && output != logger.OutputSyslog {
+		// Panics and runtime errors are written to the log file too.
+		err = daemon.RedirectOutput(output)
+	} else {
+		// Output could be redirected to the previous log file.
+		err = daemon.RestoreOutput()
+	}
+	if err != nil {
+		return err
+	}
+
+	levelName, _ := config.Configurations.GetString("log.level")
+	level, err := logger.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(level)
+
+	return nil
+}
+
+// loadConfig loads configuration file and applies command line overrides.
+// Daemon can run without configuration file, but the one given on the command
+// line should exist.
+func loadConfig() os.Error {
+	_, err := os.Stat(*configFile)
+	if err == nil || *configFile != config.DefaultFilename {
+		return config.Load(*configFile, overrides)
+	}
+
+	return config.LoadDefaults(overrides)
+}
+
+// reloadConfig reloads configuration file on SIGHUP. Current configuration
+// is kept if new one is invalid.
+func reloadConfig() {
+	err := loadConfig()
+	if err != nil {
+		log.Error("Configuration isn't reloaded. %s", err)
+		return
+	}
+
+	log.Info("Configuration is reloaded")
+}
+
+// setupClients sets connection limits.
+func setupClients(cfg *config.Config) {
+	maxClients, _ := cfg.GetInt("clients.max")
+	server.SetMaxClients(maxClients)
+	timeout, _ := cfg.GetDuration("clients.timeout")
+	server.SetIdleTimeout(timeout)
+	maxLine, _ := cfg.GetInt("clients.max_line")
+	server.SetMaxLineLength(maxLine)
+	shutdownTimeout, _ := cfg.GetDuration("clients.shutdown_timeout")
+	server.SetShutdownTimeout(shutdownTimeout)
+}
+
+// validateClients checks connection limits.
+func validateClients(cfg *config.Config) os.Error {
+	maxClients, _ := cfg.GetInt("clients.max")
+	if maxClients < 0 {
+		return os.NewError("Number of clients can't be negative")
+	}
+	maxLine, _ := cfg.GetInt("clients.max_line")
+	if maxLine < server.MinLineLength {
+		return os.NewError(fmt.Sprintf("Maximal line length can't be less than %d", server.MinLineLength))
+	}
+
+	return nil
+}
+
+// validateLog checks log destination and level.
+func validateLog(cfg *config.Config) os.Error {
+	levelName, _ := cfg.GetString("log.level")
+	_, err := logger.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	output, _ := cfg.GetString("log.output")
+	if output != logger.OutputStderr && output != logger.OutputSyslog {
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	return nil
+}
+
+// watchConfig registers watchers of the items daemon itself depends on,
+// so their changes take effect without restart.
+func watchConfig() {
+	for _, key := range []string{"clients.max", "clients.timeout", "clients.max_line", "clients.shutdown_timeout"} {
+		config.Watch(key, validateClients, setupClients)
+	}
+	for _, key := range []string{"log.level", "log.output"} {
+		config.Watch(key, validateLog, func(*config.Config) {
+			err := setupLog()
+			if err != nil {
+				log.Error("%s", err)
+			}
+		})
+	}
 }
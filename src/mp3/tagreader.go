@@ -37,7 +37,6 @@ func (tr *TagReader) ReadTag(filename string) (tag *audio.Tag, err os.Error) {
 	tag.Artist = id3Tag.Artist()
 	tag.Album = id3Tag.Album()
 	tag.Title = id3Tag.Title()
-	tag.Length = "0:00"
 
 	return tag, nil
 }
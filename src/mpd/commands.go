@@ -0,0 +1,472 @@
+// MPD commands implementation.
+package mpd
+
+import (
+	"os"
+	"fmt"
+	"path"
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"./vfs"
+	"./player"
+	"./playlist"
+	"./event"
+	"./auth"
+	"./utils"
+)
+
+// Virtual track URI suffix for the tracks of the cue sheets.
+var cueTrackRegexp = regexp.MustCompile("^(.+)/track([0-9]{4})$")
+
+// MPD idle subsystems for every chubd subsystem.
+var idleSubsystems = map[string][]string{
+	event.Player:    []string{"player"},
+	event.Track:     []string{"player"},
+	event.Playlist:  []string{"playlist", "stored_playlist"},
+	event.Playlists: []string{"stored_playlist"},
+	event.Library:   []string{"database"},
+}
+
+// Queue version, which is incremented every time any playlist is modified.
+var queueVersion = 1
+// Mutex for protecting queueVersion.
+var queueVersionMutex sync.Mutex
+
+// songURI returns MPD URI of the track.
+func songURI(track *vfs.Track) string {
+	uri := strings.TrimLeft(track.FilePath.Path(), "/")
+	if track.Number > 0 {
+		uri = fmt.Sprintf("%s/track%04d", uri, track.Number)
+	}
+
+	return uri
+}
+
+// parseURI returns VFS path and track number for the MPD URI.
+func parseURI(uri string) (filename *vfs.Path, number int) {
+	if m := cueTrackRegexp.FindStringSubmatch(uri); m != nil {
+		number, _ = strconv.Atoi(m[2])
+		return vfs.NewPath(path.Clean("/" + m[1])), number
+	}
+
+	return vfs.NewPath(path.Clean("/" + uri)), 0
+}
+
+// queue returns the playlist used as MPD queue.
+func queue() *playlist.Playlist {
+	pl, _ := player.Playlist(vfs.PlaylistName) // This playlist should be present always.
+	return pl
+}
+
+// writeSong writes song description lines.
+func writeSong(writer *bufio.Writer, track *vfs.Track) {
+	writer.WriteString(fmt.Sprintf("file: %s\n", songURI(track)))
+	writer.WriteString(fmt.Sprintf("Time: %d\n", track.Len()))
+	if track.Tag != nil {
+		writer.WriteString(fmt.Sprintf("Artist: %s\n", track.Tag.Artist))
+		writer.WriteString(fmt.Sprintf("Album: %s\n", track.Tag.Album))
+		writer.WriteString(fmt.Sprintf("Title: %s\n", track.Tag.Title))
+		writer.WriteString(fmt.Sprintf("Track: %s\n", track.Tag.Number))
+	}
+}
+
+// writeQueueSong writes song description with its queue position.
+// Position is used as song id since chubd has no stable song ids.
+func writeQueueSong(writer *bufio.Writer, track *vfs.Track, pos int) {
+	writeSong(writer, track)
+	writer.WriteString(fmt.Sprintf("Pos: %d\n", pos))
+	writer.WriteString(fmt.Sprintf("Id: %d\n", pos))
+}
+
+// parseInt parses integer argument.
+func parseInt(str string) (n int, err os.Error) {
+	n, err = strconv.Atoi(str)
+	if err != nil {
+		return 0, newAck(ackErrorArg, "need an integer")
+	}
+
+	return n, nil
+}
+
+// mpdPing does nothing.
+func mpdPing(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	return nil
+}
+
+// mpdPassword authenticates the client.
+// Arguments:
+// * password
+func mpdPassword(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	level, err := auth.Authenticate(cmd.Args[0], h.remoteAddr)
+	if err != nil {
+		return newAck(ackErrorPassword, "incorrect password")
+	}
+
+	h.level = level
+
+	return nil
+}
+
+// mpdCommands prints commands available for the client.
+func mpdCommands(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	for _, name := range h.commandNames(true) {
+		writer.WriteString(fmt.Sprintf("command: %s\n", name))
+	}
+
+	return nil
+}
+
+// mpdNotCommands prints commands not available for the client.
+func mpdNotCommands(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	for _, name := range h.commandNames(false) {
+		writer.WriteString(fmt.Sprintf("command: %s\n", name))
+	}
+
+	return nil
+}
+
+// commandNames returns sorted list of the commands which client is (or is not)
+// allowed to execute.
+func (h *CommandHandler) commandNames(allowed bool) []string {
+	names := make([]string, 0, len(commandDescriptors))
+	for name, descriptor := range commandDescriptors {
+		if (descriptor.permission <= h.level) == allowed {
+			names = append(names, name)
+		}
+	}
+	utils.StringArray(names).Sort()
+
+	return names
+}
+
+// mpdTagTypes prints supported tag types.
+func mpdTagTypes(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	for _, tag := range []string{"Artist", "Album", "Title", "Track"} {
+		writer.WriteString(fmt.Sprintf("tagtype: %s\n", tag))
+	}
+
+	return nil
+}
+
+// mpdStatus prints player and queue status.
+func mpdStatus(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	status := player.CurrentStatus()
+
+	queueVersionMutex.Lock()
+	version := queueVersion
+	queueVersionMutex.Unlock()
+
+	state := "stop"
+	switch status.State {
+	case "playing":
+		state = "play"
+	case "paused":
+		state = "pause"
+	}
+
+	writer.WriteString("volume: -1\n")
+	writer.WriteString("repeat: 0\n")
+	writer.WriteString("random: 0\n")
+	writer.WriteString("single: 0\n")
+	writer.WriteString("consume: 0\n")
+	writer.WriteString(fmt.Sprintf("playlist: %d\n", version))
+	writer.WriteString(fmt.Sprintf("playlistlength: %d\n", queue().Len()))
+	writer.WriteString(fmt.Sprintf("state: %s\n", state))
+	if status.Track != nil {
+		if status.Playlist == vfs.PlaylistName {
+			writer.WriteString(fmt.Sprintf("song: %d\n", status.Position))
+			writer.WriteString(fmt.Sprintf("songid: %d\n", status.Position))
+		}
+		writer.WriteString(fmt.Sprintf("time: %d:%d\n", status.Elapsed, status.Length))
+		writer.WriteString(fmt.Sprintf("elapsed: %d.000\n", status.Elapsed))
+		writer.WriteString(fmt.Sprintf("audio: %d:16:%d\n", status.SampleRate, status.Channels))
+	}
+
+	return nil
+}
+
+// mpdCurrentSong prints the song is being played.
+func mpdCurrentSong(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	status := player.CurrentStatus()
+	if status.Track == nil {
+		return nil
+	}
+
+	// Position is meaningful only for the queue, not for other playlists.
+	if status.Playlist == vfs.PlaylistName {
+		writeQueueSong(writer, status.Track, status.Position)
+	} else {
+		writeSong(writer, status.Track)
+	}
+
+	return nil
+}
+
+// mpdPlay starts playing the queue.
+// Arguments:
+// * optional song position; playing is resumed if omitted and player is paused
+func mpdPlay(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	pos := 0
+	if len(cmd.Args) > 0 {
+		n, err := parseInt(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		pos = n
+	} else {
+		status := player.CurrentStatus()
+		if status.State == "paused" {
+			player.Pause()
+			return nil
+		}
+		if status.Track != nil && status.Playlist == vfs.PlaylistName {
+			pos = status.Position
+		}
+	}
+
+	err := player.Play(vfs.PlaylistName, pos)
+	if err != nil {
+		return newAck(ackErrorArg, "Bad song index")
+	}
+
+	return nil
+}
+
+// mpdPause toggles pause state.
+// Arguments:
+// * optional 1 to pause or 0 to resume playing
+func mpdPause(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	state := player.CurrentStatus().State
+	if len(cmd.Args) > 0 {
+		switch cmd.Args[0] {
+		case "1":
+			if state != "playing" {
+				return nil
+			}
+		case "0":
+			if state != "paused" {
+				return nil
+			}
+		default:
+			return newAck(ackErrorArg, "Boolean (0/1) expected: %s", cmd.Args[0])
+		}
+	}
+
+	player.Pause()
+
+	return nil
+}
+
+// mpdStop stops playing.
+func mpdStop(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	player.Stop()
+
+	return nil
+}
+
+// mpdNext switches to the next song.
+func mpdNext(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	player.Next()
+
+	return nil
+}
+
+// mpdPrevious switches to the previous song.
+func mpdPrevious(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	player.Previous()
+
+	return nil
+}
+
+// mpdLsInfo prints directory content. Stored playlists are printed
+// for the root directory also.
+// Arguments:
+// * optional directory URI
+func mpdLsInfo(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	uri := ""
+	if len(cmd.Args) > 0 {
+		uri = cmd.Args[0]
+	}
+	dir := vfs.NewPath(path.Clean("/" + uri))
+
+	entries, err := h.fs.ListDir(dir)
+	if err != nil {
+		return newAck(ackErrorNoExist, "directory not found")
+	}
+
+	for _, entry := range entries {
+		switch entry.Type() {
+		case vfs.TypeDirectory:
+			name := strings.TrimLeft(entry.Directory().Filename.Path(), "/")
+			writer.WriteString(fmt.Sprintf("directory: %s\n", name))
+		case vfs.TypeTrack:
+			writeSong(writer, entry.Track())
+		}
+	}
+
+	if dir.Path() == "/" {
+		writeStoredPlaylists(writer)
+	}
+
+	return nil
+}
+
+// mpdAdd appends song or whole directory to the queue.
+// Arguments:
+// * song or directory URI
+func mpdAdd(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	filename, number := parseURI(cmd.Args[0])
+
+	var tracks []*vfs.Track
+	var err os.Error
+	if h.fs.IsDirectory(filename) {
+		tracks, err = h.fs.TracksRecursive(filename)
+	} else {
+		var track *vfs.Track
+		track, err = h.fs.Track(filename, number)
+		tracks = []*vfs.Track{track}
+	}
+	if err != nil {
+		return newAck(ackErrorNoExist, "%s", err)
+	}
+
+	queue().Append(tracks...)
+
+	return nil
+}
+
+// mpdPlaylistInfo prints queue content.
+// Arguments:
+// * optional song position
+func mpdPlaylistInfo(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	tracks := queue().Tracks()
+
+	if len(cmd.Args) > 0 {
+		pos, err := parseInt(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		if pos < 0 || pos >= len(tracks) {
+			return newAck(ackErrorArg, "Bad song index")
+		}
+
+		writeQueueSong(writer, tracks[pos], pos)
+		return nil
+	}
+
+	for i, track := range tracks {
+		writeQueueSong(writer, track, i)
+	}
+
+	return nil
+}
+
+// mpdListPlaylists prints stored playlists.
+func mpdListPlaylists(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	writeStoredPlaylists(writer)
+
+	return nil
+}
+
+// writeStoredPlaylists writes names of the user playlists.
+func writeStoredPlaylists(writer *bufio.Writer) {
+	for _, pl := range player.Playlists() {
+		if !pl.IsSystem() {
+			writer.WriteString(fmt.Sprintf("playlist: %s\n", pl.Name()))
+		}
+	}
+}
+
+// mpdIdle waits till any of the given subsystems is changed and prints changed ones.
+// Waiting can be canceled with noidle command.
+// Arguments:
+// * MPD subsystem names, all subsystems are watched if omitted
+func mpdIdle(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+	// Requested MPD subsystems.
+	requested := make(map[string]bool)
+	for _, name := range cmd.Args {
+		requested[name] = true
+	}
+
+	// Corresponding chubd subsystems.
+	subsystems := make([]string, 0, len(event.Subsystems))
+	for _, s := range event.Subsystems {
+		for _, name := range idleSubsystems[s] {
+			if len(requested) == 0 || requested[name] {
+				subsystems = append(subsystems, s)
+				break
+			}
+		}
+	}
+	if len(subsystems) == 0 {
+		return newAck(ackErrorArg, "Unrecognized idle event: %s", cmd.Args[0])
+	}
+
+	for {
+		changed := h.Listener.Wait(subsystems, h.NoIdle)
+		if changed == nil {
+			return nil
+		}
+
+		// Several chubd subsystems can be mapped to the same MPD one.
+		printed := make(map[string]bool)
+		for _, s := range changed {
+			for _, name := range idleSubsystems[s] {
+				if (len(requested) == 0 || requested[name]) && !printed[name] {
+					writer.WriteString(fmt.Sprintf("changed: %s\n", name))
+					printed[name] = true
+				}
+			}
+		}
+		if len(printed) > 0 {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// watchQueue increments queue version on every playlist modification.
+func watchQueue() {
+	listener := event.Subscribe()
+	for {
+		listener.Wait([]string{event.Playlist}, nil)
+
+		queueVersionMutex.Lock()
+		queueVersion++
+		queueVersionMutex.Unlock()
+	}
+}
+
+// Package init function.
+func init() {
+	commandDescriptors = map[string]commandDescriptor{
+		"ping":          commandDescriptor{0, 0, mpdPing, false, auth.LevelNone},
+		"password":      commandDescriptor{1, 1, mpdPassword, false, auth.LevelNone},
+		"commands":      commandDescriptor{0, 0, mpdCommands, false, auth.LevelNone},
+		"notcommands":   commandDescriptor{0, 0, mpdNotCommands, false, auth.LevelNone},
+		"tagtypes":      commandDescriptor{0, 0, mpdTagTypes, false, auth.LevelRead},
+		"status":        commandDescriptor{0, 0, mpdStatus, false, auth.LevelRead},
+		"currentsong":   commandDescriptor{0, 0, mpdCurrentSong, false, auth.LevelRead},
+		"play":          commandDescriptor{0, 1, mpdPlay, false, auth.LevelControl},
+		"pause":         commandDescriptor{0, 1, mpdPause, false, auth.LevelControl},
+		"stop":          commandDescriptor{0, 0, mpdStop, false, auth.LevelControl},
+		"next":          commandDescriptor{0, 0, mpdNext, false, auth.LevelControl},
+		"previous":      commandDescriptor{0, 0, mpdPrevious, false, auth.LevelControl},
+		"lsinfo":        commandDescriptor{0, 1, mpdLsInfo, false, auth.LevelRead},
+		"add":           commandDescriptor{1, 1, mpdAdd, false, auth.LevelAdd},
+		"playlistinfo":  commandDescriptor{0, 1, mpdPlaylistInfo, false, auth.LevelRead},
+		"listplaylists": commandDescriptor{0, 0, mpdListPlaylists, false, auth.LevelRead},
+		"idle":          commandDescriptor{0, -1, mpdIdle, true, auth.LevelRead},
+		"noidle":        commandDescriptor{0, 0, mpdPing, false, auth.LevelRead},
+	}
+}
+
+// Start starts the queue version watcher. It should be called once before
+// the MPD server starts accepting connections.
+func Start() {
+	go watchQueue()
+}
@@ -0,0 +1,282 @@
+// Mpd package implements subset of the MPD (Music Player Daemon) protocol,
+// so existing MPD clients can control chubd.
+//
+// MPD queue is mapped to the *vfs* playlist, MPD stored playlists are
+// the user playlists. Song URIs are VFS paths without the leading slash.
+// Tracks of the cue sheets are exposed as virtual tracks:
+// <audio file URI>/trackNNNN.
+package mpd
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"bufio"
+	"strings"
+	"./server"
+	"./vfs"
+	"./auth"
+	"./session"
+	"./utils"
+)
+
+// Version of the MPD protocol which is reported to clients.
+const protocolVersion = "0.16.0"
+
+// MPD error codes (ACK_ERROR_*).
+const (
+	ackErrorNotList    = 1
+	ackErrorArg        = 2
+	ackErrorPassword   = 3
+	ackErrorPermission = 4
+	ackErrorUnknown    = 5
+	ackErrorNoExist    = 50
+	ackErrorSystem     = 52
+)
+
+// ackError is the error returned to the client with ACK line.
+type ackError struct {
+	// MPD error code.
+	code int
+	// Error description.
+	message string
+}
+
+// newAck returns new MPD error with the given code.
+func newAck(code int, format string, args ...interface{}) os.Error {
+	return &ackError{code, fmt.Sprintf(format, args...)}
+}
+
+// String returns error description.
+func (err *ackError) String() string {
+	return err.message
+}
+
+// command represents parsed MPD command.
+type command struct {
+	// Name of the command.
+	Name string
+	// Command arguments.
+	Args []string
+}
+
+// commandHandler is the command handler function. Response lines should be
+// written to the writer, final OK line is written by caller.
+type commandHandler func(h *CommandHandler, writer *bufio.Writer, cmd *command) os.Error
+
+// commandDescriptor describes MPD command.
+type commandDescriptor struct {
+	// Minimal number of arguments.
+	minArgs int
+	// Maximal number of arguments. -1 means any number of arguments.
+	maxArgs int
+	// Handler function for the command.
+	handler commandHandler
+	// true if command can block for a long time. Such commands are not executed
+	// under the player lock and can't be used in command lists.
+	blocking bool
+	// Permission level required to execute the command.
+	permission auth.Level
+}
+
+// All supported commands descriptors. commandDescriptors is initialized
+// in init() because "commands" handler refers to it.
+var commandDescriptors map[string]commandDescriptor
+
+// CommandHandler handles commands of one MPD client connection.
+type CommandHandler struct {
+	// idle subscription and command list state.
+	// Session's Close implements server.Closer interface.
+	*session.Session
+	fs *vfs.Filesystem
+	// Permission level of the connection.
+	level auth.Level
+	// Client address failed passwords are counted for.
+	remoteAddr string
+}
+
+// NewCommandHandler creates new initialized MPD command handler.
+func NewCommandHandler() *CommandHandler {
+	h := new(CommandHandler)
+	h.Session = session.New()
+	h.fs = vfs.New()
+	h.level = auth.DefaultLevel()
+
+	return h
+}
+
+// Level returns permission level of the connection.
+func (h *CommandHandler) Level() auth.Level {
+	return h.level
+}
+
+// Greeting implements server.Greeter interface.
+func (h *CommandHandler) Greeting() string {
+	return fmt.Sprintf("OK MPD %s\n", protocolVersion)
+}
+
+// Rejection implements server.Rejecter interface.
+func (h *CommandHandler) Rejection(reason string) string {
+	return fmt.Sprintf("ACK [%d@0] {} %s\n", ackErrorSystem, reason)
+}
+
+// Interrupt implements server.Interruptible interface.
+// noidle command recieved while idle is running cancels it. noidle
+// recieved while other command is running is handled as usual one.
+func (h *CommandHandler) Interrupt(running string, request string) bool {
+	cmd, err := parseCommand(running)
+	if err != nil || cmd.Name != "idle" || strings.TrimSpace(request) != "noidle" {
+		return false
+	}
+
+	h.CancelIdle()
+
+	return true
+}
+
+// HandleCommand implements server.CommandHandler interface.
+func (h *CommandHandler) HandleCommand(writer *bufio.Writer, request string) bool {
+	defer writer.Flush()
+
+	cmd, err := parseCommand(request)
+	if err != nil {
+		h.AbortList()
+		writeAck(writer, err, 0, "")
+		return false
+	}
+
+	if h.InList() {
+		if cmd.Name == "command_list_end" {
+			h.runList(writer, h.EndList())
+		} else {
+			h.AddToList(cmd)
+		}
+		return false
+	}
+
+	switch cmd.Name {
+	case "close":
+		return true
+	case "command_list_begin", "command_list_ok_begin":
+		h.BeginList(cmd.Name == "command_list_ok_begin")
+		return false
+	case "command_list_end":
+		writeAck(writer, newAck(ackErrorNotList, "not in command list mode"), 0, cmd.Name)
+		return false
+	}
+
+	err = h.execute(writer, cmd)
+
+	// noidle consumed while idle was finishing by itself has no effect.
+	h.DropCancelIdle()
+
+	if err != nil {
+		writeAck(writer, err, 0, cmd.Name)
+	} else {
+		writer.WriteString("OK\n")
+	}
+
+	return false
+}
+
+// execute runs single command. Non-blocking commands are executed under the player lock.
+func (h *CommandHandler) execute(writer *bufio.Writer, cmd *command) os.Error {
+	return session.Execute(isBlocking(cmd), func() os.Error {
+		return h.run(writer, cmd)
+	})
+}
+
+// runList executes recieved commands list. Execution is stopped on the first error.
+func (h *CommandHandler) runList(writer *bufio.Writer, list []interface{}) {
+	err := session.RunList(list, func(i int, c interface{}) os.Error {
+		cmd := c.(*command)
+		var err os.Error
+		if isBlocking(cmd) {
+			err = newAck(ackErrorArg, "%s not allowed in command list", cmd.Name)
+		} else {
+			err = h.run(writer, cmd)
+		}
+		if err != nil {
+			writeAck(writer, err, i, cmd.Name)
+			return err
+		}
+
+		if h.ListOk() {
+			writer.WriteString("list_OK\n")
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		writer.WriteString("OK\n")
+	}
+}
+
+// isBlocking returns true if command can block for a long time.
+func isBlocking(cmd *command) bool {
+	descriptor, ok := commandDescriptors[cmd.Name]
+
+	return ok && descriptor.blocking
+}
+
+// run validates and executes command.
+func (h *CommandHandler) run(writer *bufio.Writer, cmd *command) os.Error {
+	descriptor, ok := commandDescriptors[cmd.Name]
+	if !ok {
+		return newAck(ackErrorUnknown, "unknown command \"%s\"", cmd.Name)
+	}
+
+	if len(cmd.Args) < descriptor.minArgs ||
+		(descriptor.maxArgs != -1 && len(cmd.Args) > descriptor.maxArgs) {
+		return newAck(ackErrorArg, "wrong number of arguments for \"%s\"", cmd.Name)
+	}
+
+	if descriptor.permission > h.level {
+		return newAck(ackErrorPermission, "you don't have permission for \"%s\"", cmd.Name)
+	}
+
+	return descriptor.handler(h, writer, cmd)
+}
+
+// writeAck writes error line: ACK [code@index] {command} message
+func writeAck(writer *bufio.Writer, err os.Error, index int, name string) {
+	code := ackErrorSystem
+	if ack, ok := err.(*ackError); ok {
+		code = ack.code
+	}
+
+	writer.WriteString(fmt.Sprintf("ACK [%d@%d] {%s} %s\n", code, index, name, err))
+}
+
+// parseCommand parses MPD request line. Arguments are separated with spaces
+// and can be enclosed in quotes. Backslash escapes next character.
+func parseCommand(line string) (cmd *command, err os.Error) {
+	tokens, err := utils.Tokenize(line)
+	if err != nil {
+		return nil, newAck(ackErrorArg, "%s", err)
+	}
+
+	if len(tokens) == 0 {
+		return nil, newAck(ackErrorUnknown, "no command given")
+	}
+
+	cmd = new(command)
+	cmd.Name = strings.ToLower(tokens[0])
+	cmd.Args = tokens[1:]
+
+	return cmd, nil
+}
+
+// ConnectionHandler implementation type.
+type ConnectionHandler func(conn net.Conn) CommandHandler
+
+// HandleConnection handle every MPD client's connection.
+func (ch ConnectionHandler) HandleConnection(conn net.Conn) server.CommandHandler {
+	h := NewCommandHandler()
+	if addr := conn.RemoteAddr(); addr != nil {
+		h.remoteAddr = addr.String()
+	}
+
+	return h
+}
@@ -11,6 +11,10 @@ import (
 // Ogg decoder implementation.
 type Decoder struct {
 	oggFile *ogggo.File
+	// Audio parameters of the opened file.
+	sampleRate int
+	channels   int
+	length     int
 }
 
 // NewDecoder returns ogg decoder implementation.
@@ -33,6 +37,10 @@ func (decoder *Decoder) Open(filename string) os.Error {
 	}
 
 	decoder.oggFile = file
+	info := file.Info()
+	decoder.sampleRate = info.Rate
+	decoder.channels = info.Channels
+	decoder.length = int(file.TimeTotal())
 
 	return nil
 }
@@ -40,10 +48,40 @@ func (decoder *Decoder) Open(filename string) os.Error {
 // See audio.Decoder.
 func (decoder *Decoder) Read(buf []byte) (read int, err os.Error) {
 	read = decoder.oggFile.Read(buf)
+	if read == 0 {
+		return 0, os.EOF
+	} else if read < 0 {
+		return 0, os.NewError(fmt.Sprintf("Ogg stream decoding failed with code %d", read))
+	}
 
 	return read, nil
 }
 
+// See audio.Decoder.
+func (decoder *Decoder) SampleRate() int {
+	return decoder.sampleRate
+}
+
+// See audio.Decoder.
+func (decoder *Decoder) Channels() int {
+	return decoder.channels
+}
+
+// See audio.Decoder.
+func (decoder *Decoder) Length() int {
+	return decoder.length
+}
+
+// See audio.Decoder.
+func (decoder *Decoder) Seek(frame int64) os.Error {
+	ret := decoder.oggFile.PcmSeek(frame)
+	if ret != 0 {
+		return os.NewError(fmt.Sprintf("Ogg stream seeking failed with code %d", ret))
+	}
+
+	return nil
+}
+
 // See audio.Decoder.
 func (decoder *Decoder) Close() {
 	decoder.oggFile.Close()
@@ -64,7 +64,5 @@ func (tr *TagReader) ReadTag(filename string) (tag *audio.Tag, err os.Error) {
 		}
 	}
 
-	tag.Length = "0:00"
-
 	return tag, nil
 }
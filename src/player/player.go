@@ -10,15 +10,30 @@ import (
 	"./audio"
 	"./ogg"
 	"./alsa"
+	"./event"
+	"./config"
 )
 
 // Player mutex. All public player commands should be protected with this mutex lock.
 var mutex sync.Mutex
+// Clients commands mutex. Commands are serialized with this lock, so a sequence
+// of commands can be executed atomically.
+var commandsMutex sync.Mutex
 // All (user and system) playlists list.
 var playlists []*playlist.Playlist
 // thread is the main player thread (goroutine wrapper).
 var thread *playingThread
 
+// Lock locks player for exclusive usage by one client's command (or commands list).
+func Lock() {
+	commandsMutex.Lock()
+}
+
+// Unlock releases lock acquired with Lock.
+func Unlock() {
+	commandsMutex.Unlock()
+}
+
 // Playlists returns list of all existent playlists.
 func Playlists() []*playlist.Playlist {
 	return playlists
@@ -51,6 +66,7 @@ func AddPlaylist(name string) os.Error {
 	}
 
 	playlists = append(playlists, pl)
+	event.Notify(event.Playlists)
 
 	return nil
 }
@@ -74,6 +90,7 @@ func DeletePlaylist(name string) os.Error {
 	}
 
 	playlists = newPlaylists
+	event.Notify(event.Playlists)
 
 	return nil
 }
@@ -93,9 +110,38 @@ func Play(playlistName string, trackNumber int) os.Error {
 			playlistName, trackNumber))
 	}
 
-	thread.Play(pl.Track(trackNumber))
+	return thread.Play(pl, trackNumber)
+}
 
-	return nil
+// RemoveTrack removes track at the given position from the playlist.
+func RemoveTrack(playlistName string, pos int) os.Error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	pl, err := getPlaylistByName(playlistName)
+	if err != nil {
+		return err
+	}
+
+	return thread.Edit(pl, pos, -1)
+}
+
+// MoveTrack moves track inside the playlist from one position to another.
+func MoveTrack(playlistName string, from int, to int) os.Error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	pl, err := getPlaylistByName(playlistName)
+	if err != nil {
+		return err
+	}
+
+	return thread.Edit(pl, from, to)
+}
+
+// CurrentStatus returns description of the current player state.
+func CurrentStatus() *Status {
+	return thread.Status()
 }
 
 // Pause pause or unpause playing process.
@@ -103,12 +149,34 @@ func Pause() {
 	thread.Pause()
 }
 
-// Stop closes playing processes, frees resources.
-// This function should be called before exiting program.
+// Stop stops playing and releases audio output.
 func Stop() {
 	thread.Stop()
 }
 
+// Next switches to the next track in the current playlist.
+func Next() {
+	thread.Next()
+}
+
+// Previous switches to the previous track in the current playlist.
+func Previous() {
+	thread.Previous()
+}
+
+// Seek changes playing position in the current track.
+// If relative is true seconds is an offset (positive or negative)
+// from the current position.
+func Seek(seconds int, relative bool) os.Error {
+	return thread.Seek(seconds, relative)
+}
+
+// Shutdown closes playing processes, frees resources.
+// This function should be called before exiting program.
+func Shutdown() {
+	thread.Kill()
+}
+
 // getPlaylistByName returns playlist for given name
 // or nil if there is no such playlist registered.
 func getPlaylistByName(name string) (playlist *playlist.Playlist, err os.Error) {
@@ -118,7 +186,7 @@ func getPlaylistByName(name string) (playlist *playlist.Playlist, err os.Error)
 		}
 	}
 
-	return nil, os.NewError(fmt.Sprintf("Playlist '%s' not found"))
+	return nil, os.NewError(fmt.Sprintf("Playlist '%s' not found", name))
 }
 
 // Package init function.
@@ -138,4 +206,19 @@ func init() {
 	// Create and start playing thread.
 	thread = newPlayingThread()
 	thread.Start()
+
+	// New output device is used right away.
+	config.Watch("audio.output", validateOutput, func(*config.Config) {
+		thread.ReopenOutput()
+	})
+}
+
+// validateOutput checks if configured output driver is available.
+func validateOutput(cfg *config.Config) os.Error {
+	name, _ := cfg.GetString("audio.output")
+	if !audio.OutputAvailable(name) {
+		return os.NewError(fmt.Sprintf("Output '%s' is not available", name))
+	}
+
+	return nil
 }
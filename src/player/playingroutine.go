@@ -3,10 +3,17 @@ package player
 
 import (
 	"os"
+	"fmt"
 	"./vfs"
 	"./audio"
+	"./playlist"
+	"./event"
+	"./logger"
 )
 
+// Log of the player subsystem.
+var log = logger.New("player")
+
 // messageType is the type for describing messages.
 type messageType int
 
@@ -19,6 +26,18 @@ const (
 	messageTypePaused
 	// Stop goroutine execution request message.
 	messageTypeKill
+	// Request for the current thread status.
+	messageTypeStatus
+	// Switch to the next track in the playlist.
+	messageTypeNext
+	// Switch to the previous track in the playlist.
+	messageTypePrevious
+	// Change playing position in the current track.
+	messageTypeSeek
+	// Reopen output driver (e. g. after output device was changed).
+	messageTypeReopenOutput
+	// Remove or move track in the playlist.
+	messageTypeEdit
 )
 
 // threadState type describes state of the playing thread.
@@ -33,12 +52,77 @@ const (
 	threadStatePaused
 )
 
+// String returns string representation of the state.
+func (state threadState) String() string {
+	switch state {
+	case threadStatePlaying:
+		return "playing"
+	case threadStatePaused:
+		return "paused"
+	}
+
+	return "stopped"
+}
+
 // message type for manipulating playingThread's behaviour.
 type message struct {
 	t    messageType
 	data interface{}
 }
 
+// playRequest is the data of the messageTypePlay message.
+type playRequest struct {
+	// Playlist to be played.
+	pl *playlist.Playlist
+	// Position of the track in the playlist to start from.
+	pos int
+	// Channel for sending playing start result back.
+	reply chan os.Error
+}
+
+// seekRequest is the data of the messageTypeSeek message.
+type seekRequest struct {
+	// New position in seconds.
+	seconds int
+	// If true seconds is an offset from the current position.
+	relative bool
+	// Channel for sending seeking result back.
+	reply chan os.Error
+}
+
+// editRequest is the data of the messageTypeEdit message.
+type editRequest struct {
+	// Playlist to be changed.
+	pl *playlist.Playlist
+	// Position of the track to be removed or moved.
+	from int
+	// New position of the track or -1 if track is removed.
+	to int
+	// Channel for sending editing result back.
+	reply chan os.Error
+}
+
+// Status describes the current player's state.
+type Status struct {
+	// State name: stopped, playing or paused.
+	State string
+	// Name of the playlist the current track belongs to.
+	// Empty if player is stopped.
+	Playlist string
+	// Position of the current track in the playlist.
+	Position int
+	// Current track. nil if player is stopped.
+	Track *vfs.Track
+	// Number of seconds played from the beginning of the track.
+	Elapsed int
+	// Total length of the track in seconds.
+	Length int
+	// Sample rate of the current track.
+	SampleRate int
+	// Number of channels of the current track.
+	Channels int
+}
+
 // playingThread structure represents thread which decode audio file and writes
 // resulting raw PCM data to the output driver. Also is manages some related stuff, like pause, seek, etc.
 type playingThread struct {
@@ -47,12 +131,23 @@ type playingThread struct {
 	// Current state.
 	state threadState
 	// Separate goroutine will write here values to let us know that we can decode and write new portion of data.
+	// false is written if output is still full after the waiting timeout.
 	bufAvailable chan bool
+	// true if goroutine waiting for the output buffer is running.
+	waiting bool
 	// Output driver.
 	output audio.Output
 	// Decoder driver implementation for the current playing track.
 	// This is not nil only if thread in threadStatePlaying state.
 	decoder audio.Decoder
+	// Playlist the current track belongs to.
+	playlist *playlist.Playlist
+	// Position of the current track in the playlist.
+	position int
+	// Track is currently played.
+	track *vfs.Track
+	// Number of PCM frames written to the output for the current track.
+	frames int64
 }
 
 // newPlayingThread returns newly initialized playingThread object.
@@ -70,8 +165,8 @@ func (thread *playingThread) Start() {
 	go thread.routine()
 }
 
-// Stop release resources and prepare for termination.
-func (thread *playingThread) Stop() {
+// Kill release resources and prepare for termination.
+func (thread *playingThread) Kill() {
 	// We send to the routine message and will wait for the answer.
 	wait := make(chan bool)
 
@@ -83,12 +178,73 @@ func (thread *playingThread) Stop() {
 	<-wait
 }
 
-// Play start playing given track.
-func (thread *playingThread) Play(track *vfs.Track) {
+// Play start playing track from the given playlist position.
+// Error is returned if the track can't be played.
+func (thread *playingThread) Play(pl *playlist.Playlist, pos int) os.Error {
+	req := &playRequest{pl, pos, make(chan os.Error)}
+
 	msg := new(message)
 	msg.t = messageTypePlay
-	msg.data = track
+	msg.data = req
+	thread.sendMessage(msg)
+
+	return <-req.reply
+}
+
+// Stop stops playing and releases decoder and output drivers.
+// Thread is still running and ready for new tracks.
+func (thread *playingThread) Stop() {
+	msg := new(message)
+	msg.t = messageTypeStop
+	thread.sendMessage(msg)
+}
+
+// Next switches to the next track in the current playlist.
+func (thread *playingThread) Next() {
+	msg := new(message)
+	msg.t = messageTypeNext
+	thread.sendMessage(msg)
+}
+
+// Previous switches to the previous track in the current playlist.
+func (thread *playingThread) Previous() {
+	msg := new(message)
+	msg.t = messageTypePrevious
+	thread.sendMessage(msg)
+}
+
+// ReopenOutput closes output driver and opens it again, so new output
+// configuration takes effect. Playing isn't interrupted.
+func (thread *playingThread) ReopenOutput() {
+	msg := new(message)
+	msg.t = messageTypeReopenOutput
+	thread.sendMessage(msg)
+}
+
+// Seek changes playing position in the current track.
+// If relative is true seconds is an offset from the current position.
+func (thread *playingThread) Seek(seconds int, relative bool) os.Error {
+	req := &seekRequest{seconds, relative, make(chan os.Error)}
+
+	msg := new(message)
+	msg.t = messageTypeSeek
+	msg.data = req
+	thread.sendMessage(msg)
+
+	return <-req.reply
+}
+
+// Edit removes (if to is -1) or moves track in the playlist. Position
+// of the current track is updated, so playing goes on from the right place.
+func (thread *playingThread) Edit(pl *playlist.Playlist, from int, to int) os.Error {
+	req := &editRequest{pl, from, to, make(chan os.Error)}
+
+	msg := new(message)
+	msg.t = messageTypeEdit
+	msg.data = req
 	thread.sendMessage(msg)
+
+	return <-req.reply
 }
 
 // Pause toggle pause state.
@@ -98,6 +254,18 @@ func (thread *playingThread) Pause() {
 	thread.sendMessage(msg)
 }
 
+// Status returns current thread state description.
+func (thread *playingThread) Status() *Status {
+	reply := make(chan *Status)
+
+	msg := new(message)
+	msg.t = messageTypeStatus
+	msg.data = reply
+	thread.sendMessage(msg)
+
+	return <-reply
+}
+
 // SendMessage queue new message for the playingThread.
 func (thread *playingThread) sendMessage(msg *message) {
 	thread.messages <- msg
@@ -105,26 +273,38 @@ func (thread *playingThread) sendMessage(msg *message) {
 
 // startBufAvailableChecker runs goroutine for checking if output buffer is avaliable
 // for new portion of data.
+// Only one such goroutine is running at a time.
 func (thread *playingThread) startBufAvailableChecker() {
-	// XXX: I think we can have more than one such goroutine running in time,
-	//      But I'll hope it is not a big problem. In other case we can provide mutex
-	//      for the nex goroutine duplicate protection.
-	if thread.state == threadStatePlaying {
+	if thread.state == threadStatePlaying && !thread.waiting {
+		thread.waiting = true
+		output := thread.output
 		go func() {
-			if thread.output.Wait(500) {
-				thread.bufAvailable <- true
-			}
+			thread.bufAvailable <- output.Wait(500)
 		}()
 	}
 }
 
+// stopBufAvailableChecker waits till the goroutine started with
+// startBufAvailableChecker is finished, so output isn't used by it anymore.
+// Its result is dropped.
+func (thread *playingThread) stopBufAvailableChecker() {
+	if thread.waiting {
+		<-thread.bufAvailable
+		thread.waiting = false
+	}
+}
+
 // openOutput intializes output driver.
 func (thread *playingThread) openOutput() os.Error {
-	output := audio.GetOutput()
-	err := output.Open()
+	output, err := audio.GetOutput()
 	if err != nil {
 		return err
 	}
+	err = output.Open()
+	if err != nil {
+		log.Error("Can't open audio output. %s", err)
+		return err
+	}
 	output.SetSampleRate(44100)
 	output.SetChannels(2)
 
@@ -133,24 +313,46 @@ func (thread *playingThread) openOutput() os.Error {
 	return nil
 }
 
+// reopenOutput reopens output driver if it is opened. Paused state is kept.
+// Playing is stopped if output can't be opened.
+func (thread *playingThread) reopenOutput() {
+	if thread.output == nil {
+		return // Output is opened when playing starts.
+	}
+
+	thread.closeOutput()
+	err := thread.openOutput()
+	if err != nil {
+		thread.stop()
+		return
+	}
+
+	if thread.state == threadStatePaused {
+		thread.output.Pause()
+	}
+}
+
 // closeOutput close and release output driver.
 func (thread *playingThread) closeOutput() {
 	if thread.output != nil {
+		thread.stopBufAvailableChecker()
 		thread.output.Close()
 		thread.output = nil
 	}
 }
 
-// openDecoder initilizes decoder driver.
-func (thread *playingThread) openDecoder(track *vfs.Track) os.Error {
+// openDecoder initilizes decoder driver for the track.
+func (thread *playingThread) openDecoder(track *vfs.Track) (audio.Decoder, os.Error) {
 	decoder, err := audio.GetDecoder(track.FilePath.PathFull())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = decoder.Open(track.FilePath.PathFull())
+	if err != nil {
+		return nil, err
 	}
-	thread.decoder = decoder
-	thread.decoder.Open(track.FilePath.PathFull())
 
-	return nil
+	return decoder, nil
 }
 
 // closeDecoder releases decoder driver.
@@ -159,12 +361,196 @@ func (thread *playingThread) closeDecoder() {
 		thread.decoder.Close()
 		thread.decoder = nil
 	}
+	thread.playlist = nil
+	thread.track = nil
+	thread.frames = 0
 }
 
-// Ruotine is the core goroutine function.
-func (thread *playingThread) routine() {
+// status returns description of the current thread state.
+func (thread *playingThread) status() *Status {
+	status := new(Status)
+	status.State = thread.state.String()
+
+	if thread.track != nil {
+		status.Playlist = thread.playlist.Name()
+		status.Position = thread.position
+		status.Track = thread.track
+		status.Length = thread.decoder.Length()
+		status.SampleRate = thread.decoder.SampleRate()
+		status.Channels = thread.decoder.Channels()
+		if status.SampleRate > 0 {
+			status.Elapsed = int(thread.frames / int64(status.SampleRate))
+		}
+	}
+
+	return status
+}
+
+// frameSize returns size of the one PCM frame (samples for all channels) in bytes.
+func (thread *playingThread) frameSize() int {
+	// We always output 16 bit samples.
+	return thread.decoder.Channels() * 2
+}
+
+// play starts playing track from the given playlist position.
+// If the track can't be played, error is returned and the current
+// state is kept: it's up to the caller to stop playing.
+func (thread *playingThread) play(pl *playlist.Playlist, pos int) os.Error {
+	track := pl.Track(pos)
+	if track == nil {
+		return os.NewError(fmt.Sprintf("Playlist '%s' has no track number %d", pl.Name(), pos))
+	}
+
+	// Initialize decoder driver.
+	decoder, err := thread.openDecoder(track)
+	if err != nil {
+		return err
+	}
+
+	// Initialize output driver.
+	if thread.output == nil {
+		err = thread.openOutput()
+		if err != nil {
+			decoder.Close()
+			return err
+		}
+	} else {
+		// If this track audio parameters (sample rate, channels number)
+		// difference from previous one we need to reconfigure output driver.
+
+		// TODO: Check for the new file format,
+		//       maybe we have to change sample rate or channels count.
+	}
+
+	// Output device keeps paused state between tracks, so release it first.
+	if thread.state == threadStatePaused {
+		thread.output.Unpause()
+	}
+
+	thread.closeDecoder()
+	thread.decoder = decoder
+	thread.playlist = pl
+	thread.position = pos
+	thread.track = track
+
+	thread.state = threadStatePlaying
+	event.Notify(event.Track)
+	event.Notify(event.Player)
+
+	return nil
+}
+
+// next starts playing the next track of the current playlist.
+// Tracks which can't be decoded are skipped. Thread is stopped
+// when the end of the playlist is reached.
+func (thread *playingThread) next() {
+	pl := thread.playlist
+	for pos := thread.position + 1; pos < pl.Len(); pos++ {
+		err := thread.play(pl, pos)
+		if err == nil {
+			return
+		}
+		log.Warning("Track %d of the playlist '%s' is skipped. %s", pos, pl.Name(), err)
+	}
+
+	thread.stop()
+}
+
+// previous starts playing the previous track of the current playlist.
+// The current track is restarted if it is the first one.
+func (thread *playingThread) previous() {
+	pl := thread.playlist
+	pos := thread.position - 1
+	if pos < 0 {
+		pos = 0
+	}
+
+	err := thread.play(pl, pos)
+	if err != nil {
+		log.Warning("Can't play track %d of the playlist '%s'. %s", pos, pl.Name(), err)
+	}
+}
+
+// seek moves decoding position of the current track to the given second.
+func (thread *playingThread) seek(seconds int, relative bool) os.Error {
+	if thread.state == threadStateStopped {
+		return os.NewError("Player is stopped")
+	}
+
+	// Length is negative for unseekable streams.
+	rate := int64(thread.decoder.SampleRate())
+	length := thread.decoder.Length()
+	if rate <= 0 || length < 0 {
+		return os.NewError("Current track is not seekable")
+	}
+
+	if relative {
+		seconds += int(thread.frames / rate)
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds > length {
+		seconds = length
+	}
+
+	frame := int64(seconds) * rate
+	err := thread.decoder.Seek(frame)
+	if err != nil {
+		return err
+	}
+	thread.frames = frame
+	event.Notify(event.Player)
+
+	return nil
+}
+
+// edit removes or moves track in the playlist and shifts position of the
+// current track if it belongs to this playlist. If the current track is
+// removed, playing goes on from the track which took its place.
+func (thread *playingThread) edit(pl *playlist.Playlist, from int, to int) os.Error {
 	var err os.Error
+	if to == -1 {
+		err = pl.Remove(from)
+	} else {
+		err = pl.Move(from, to)
+	}
+	if err != nil || pl != thread.playlist {
+		return err
+	}
+
+	pos := thread.position
+	switch {
+	case from == pos && to == -1:
+		thread.position = from - 1
+		thread.next()
+		return nil
+	case from == pos:
+		thread.position = to
+	case from < pos && (to == -1 || to >= pos):
+		thread.position--
+	case from > pos && to != -1 && to <= pos:
+		thread.position++
+	}
+	if thread.position != pos {
+		event.Notify(event.Player)
+	}
+
+	return nil
+}
+
+// stop stops playing and releases decoder and output drivers.
+func (thread *playingThread) stop() {
+	thread.closeDecoder()
+	thread.closeOutput()
+	if thread.state != threadStateStopped {
+		thread.state = threadStateStopped
+		event.Notify(event.Player)
+	}
+}
 
+// Ruotine is the core goroutine function.
+func (thread *playingThread) routine() {
 	for {
 		thread.startBufAvailableChecker()
 
@@ -173,66 +559,87 @@ func (thread *playingThread) routine() {
 			// Change thread state.
 			switch msg.t {
 			case messageTypePlay:
-				track := msg.data.(*vfs.Track)
-
-				// Initialize decoder driver.
-				if thread.decoder != nil {
-					thread.closeDecoder()
-				}
-				err = thread.openDecoder(track)
+				req := msg.data.(*playRequest)
+				err := thread.play(req.pl, req.pos)
 				if err != nil {
-					// TODO: Write into log about unsupported decoder.
-					continue // for loop
-				}
-
-				// Initialize output driver.
-				if thread.output == nil {
-					err = thread.openOutput()
-					if err != nil {
-						// TODO: Write to log.
-						continue // for loop
-					}
-				} else {
-					// If this track audio parameters (sample rate, channels number)
-					// difference from previous one we need to reconfigure output driver.
-
-					// TODO: Check for the new file format,
-					//       maybe we have to change sample rate or channels count.
+					log.Warning("Can't play track %d of the playlist '%s'. %s", req.pos, req.pl.Name(), err)
 				}
-
-				thread.state = threadStatePlaying
-				thread.startBufAvailableChecker()
+				req.reply <- err
 			case messageTypePaused:
 				if thread.state == threadStatePlaying {
 					thread.output.Pause()
 					thread.state = threadStatePaused
+					event.Notify(event.Player)
 				} else if thread.state == threadStatePaused {
 					thread.output.Unpause()
 					thread.state = threadStatePlaying
+					event.Notify(event.Player)
 				}
 			case messageTypeStop:
-				thread.closeDecoder()
-				thread.closeOutput()
-				thread.state = threadStateStopped
+				thread.stop()
+			case messageTypeNext:
+				if thread.state != threadStateStopped {
+					thread.next()
+				}
+			case messageTypePrevious:
+				if thread.state != threadStateStopped {
+					thread.previous()
+				}
 			case messageTypeKill:
-				thread.closeDecoder()
-				thread.closeOutput()
-				thread.state = threadStateStopped
+				thread.stop()
 
 				msg.data.(chan bool) <- true
 
 				return
+			case messageTypeStatus:
+				msg.data.(chan *Status) <- thread.status()
+			case messageTypeSeek:
+				req := msg.data.(*seekRequest)
+				req.reply <- thread.seek(req.seconds, req.relative)
+			case messageTypeReopenOutput:
+				thread.reopenOutput()
+			case messageTypeEdit:
+				req := msg.data.(*editRequest)
+				req.reply <- thread.edit(req.pl, req.from, req.to)
+			}
+		case available := <-thread.bufAvailable:
+			thread.waiting = false
+			if available {
+				thread.decode()
 			}
-		case <-thread.bufAvailable:
-			// pass
 		}
+	}
+}
 
-		// Do some job.
-		if thread.state == threadStatePlaying {
-			size, _ := thread.output.AvailUpdate()
-			buf := make([]byte, size)
-			thread.decoder.Read(buf)
-			thread.output.Write(buf)
+// decode decodes next portion of the current track and writes it to the output.
+// It should be called only when output buffer has free space: messages
+// (e. g. STATUS requests) must not cause decoding.
+func (thread *playingThread) decode() {
+	if thread.state != threadStatePlaying {
+		return
+	}
+
+	size, err := thread.output.AvailUpdate()
+	if err != nil {
+		log.Warning("Output buffer check failed. %s", err)
+		return
+	}
+	// Only whole frames are decoded.
+	size -= size % thread.frameSize()
+	if size <= 0 {
+		return // Output buffer is full.
+	}
+
+	buf := make([]byte, size)
+	read, err := thread.decoder.Read(buf)
+	if err != nil {
+		// End of stream (or broken stream), so move to the next track.
+		if err != os.EOF {
+			log.Warning("Decoding of '%s' failed. %s", thread.track.FilePath.Path(), err)
 		}
+		thread.next()
+		return
 	}
+	thread.output.Write(buf[:read])
+	thread.frames += int64(read / thread.frameSize())
 }
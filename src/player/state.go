@@ -0,0 +1,175 @@
+// Player state persistence.
+package player
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+	"./vfs"
+	"./playlist"
+	"./event"
+)
+
+// State file consists of lines:
+//   playlist <name>
+//   track <filename>:<track_number>
+//   position <state> <track position> <elapsed seconds> <playlist name>
+// Tracks belong to the last mentioned playlist. Position line is written
+// only if player isn't stopped.
+const (
+	statePlaylistPrefix = "playlist "
+	stateTrackPrefix    = "track "
+	statePositionPrefix = "position "
+)
+
+// savedPosition is the playing position restored from the state file.
+type savedPosition struct {
+	state    string
+	playlist string
+	position int
+	elapsed  int
+}
+
+// Save writes all playlists (including system ones) and playing position
+// to the file. Player should be still running. New state is written to the
+// temporary file first, so the old one isn't lost if writing failed.
+func Save(filename string) os.Error {
+	status := CurrentStatus()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tmpFilename := filename + ".tmp"
+	file, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, pl := range playlists {
+		writer.WriteString(fmt.Sprintf("%s%s\n", statePlaylistPrefix, pl.Name()))
+		for _, track := range pl.Tracks() {
+			writer.WriteString(fmt.Sprintf("%s%s:%d\n", stateTrackPrefix, track.FilePath.Path(), track.Number))
+		}
+	}
+	if status.Track != nil {
+		writer.WriteString(fmt.Sprintf("%s%s %d %d %s\n", statePositionPrefix, status.State,
+			status.Position, status.Elapsed, status.Playlist))
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmpFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	return nil
+}
+
+// Load restores playlists and playing position saved with Save. Missing file
+// is not an error. Tracks which can't be found anymore are skipped.
+func Load(filename string) os.Error {
+	pos, err := load(filename)
+	if err != nil || pos == nil {
+		return err
+	}
+
+	err = Play(pos.playlist, pos.position)
+	if err != nil {
+		log.Warning("Playing position isn't restored. %s", err)
+		return nil
+	}
+	if pos.elapsed > 0 {
+		err = Seek(pos.elapsed, false)
+		if err != nil {
+			log.Warning("Playing position isn't restored. %s", err)
+		}
+	}
+	if pos.state == "paused" {
+		Pause()
+	}
+
+	return nil
+}
+
+// load restores playlists and returns saved playing position
+// (nil if player was stopped).
+func load(filename string) (pos *savedPosition, err os.Error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Error == os.ENOENT {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	fs := vfs.New()
+	var pl *playlist.Playlist
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == os.EOF && line == "" {
+			break
+		} else if err != nil && err != os.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, statePlaylistPrefix):
+			name := line[len(statePlaylistPrefix):]
+			pl, _ = getPlaylistByName(name)
+			if pl == nil {
+				pl = playlist.New(name)
+				playlists = append(playlists, pl)
+			}
+			pl.Clear()
+		case strings.HasPrefix(line, stateTrackPrefix) && pl != nil:
+			ref := line[len(stateTrackPrefix):]
+			i := strings.LastIndex(ref, ":")
+			if i == -1 {
+				continue
+			}
+			number, err := strconv.Atoi(ref[i+1:])
+			if err != nil {
+				continue
+			}
+			track, err := fs.Track(vfs.NewPath(ref[:i]), number)
+			if err != nil {
+				continue
+			}
+			pl.Append(track)
+		case strings.HasPrefix(line, statePositionPrefix):
+			fields := strings.SplitN(line[len(statePositionPrefix):], " ", 4)
+			if len(fields) != 4 {
+				continue
+			}
+			position, err := strconv.Atoi(fields[1])
+			if err != nil {
+				continue
+			}
+			elapsed, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			pos = &savedPosition{fields[0], fields[3], position, elapsed}
+		}
+	}
+
+	event.Notify(event.Playlists)
+
+	return pos, nil
+}
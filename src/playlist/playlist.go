@@ -2,8 +2,11 @@
 package playlist
 
 import (
+	"os"
+	"fmt"
 	"sync"
 	"./vfs"
+	"./event"
 )
 
 // Playlist
@@ -33,16 +36,32 @@ func (pl *Playlist) Name() string {
 
 // Tracks returns list of tracks presented in the playlist.
 func (pl *Playlist) Tracks() []*vfs.Track {
-	return pl.tracks
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	tracks := make([]*vfs.Track, len(pl.tracks))
+	copy(tracks, pl.tracks)
+
+	return tracks
 }
 
-// Track returns track by its position.
+// Track returns track by its position or nil if there is no such position.
 func (pl *Playlist) Track(n int) *vfs.Track {
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	if n < 0 || n >= len(pl.tracks) {
+		return nil
+	}
+
 	return pl.tracks[n]
 }
 
 // Len returns the total number of tracks present in playlist. 
 func (pl *Playlist) Len() int {
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
 	return len(pl.tracks)
 }
 
@@ -58,6 +77,7 @@ func (pl *Playlist) Append(tracks ...*vfs.Track) {
 	defer pl.mtx.Unlock()
 
 	pl.tracks = append(pl.tracks, tracks...)
+	event.Notify(event.Playlist)
 }
 
 // Clear removes all items from the playlist.
@@ -66,4 +86,44 @@ func (pl *Playlist) Clear() {
 	defer pl.mtx.Unlock()
 
 	pl.tracks = make([]*vfs.Track, 0)
+	event.Notify(event.Playlist)
+}
+
+// Remove removes track at the given position.
+func (pl *Playlist) Remove(pos int) os.Error {
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	if pos < 0 || pos >= len(pl.tracks) {
+		return os.NewError(fmt.Sprintf("Playlist '%s' has no track number %d", pl.name, pos))
+	}
+
+	pl.tracks = append(pl.tracks[:pos], pl.tracks[pos+1:]...)
+	event.Notify(event.Playlist)
+
+	return nil
+}
+
+// Move moves track from one position to another. Tracks between
+// these positions are shifted.
+func (pl *Playlist) Move(from int, to int) os.Error {
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	for _, pos := range []int{from, to} {
+		if pos < 0 || pos >= len(pl.tracks) {
+			return os.NewError(fmt.Sprintf("Playlist '%s' has no track number %d", pl.name, pos))
+		}
+	}
+
+	track := pl.tracks[from]
+	if from < to {
+		copy(pl.tracks[from:to], pl.tracks[from+1:to+1])
+	} else {
+		copy(pl.tracks[to+1:from+1], pl.tracks[to:from])
+	}
+	pl.tracks[to] = track
+	event.Notify(event.Playlist)
+
+	return nil
 }
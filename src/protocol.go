@@ -6,147 +6,689 @@ import (
 	"net"
 	"bufio"
 	"fmt"
-	"scanner"
+	"bytes"
+	"json"
+	"sync"
 	"strings"
 	"strconv"
+	"time"
+	"crypto/tls"
 	"./server"
 	"./vfs"
 	"./player"
+	"./playlist"
+	"./event"
+	"./auth"
+	"./logger"
+	"./config"
+	"./session"
+	"./utils"
 )
 
+// Log of the protocol subsystem.
+var log = logger.New("protocol")
+
 // command represents parsed command.
 type command struct {
 	// Name of the command.
 	Name string
 	// Command parameters represented as strings (as they were sent by client).
 	Parameters []string
+	// Parameters converted according to the command descriptor before
+	// the handler is called. Omitted optional parameters are not present.
+	Args []interface{}
 }
 
 // Field constants.
 const (
-	fieldNameType     = "Type"
-	fieldNameFilename = "Filename"
-	fieldNameArtist   = "Artist"
-	fieldNameAlbum    = "Album"
-	fieldNameTitle    = "Title"
-	fieldNameNumber   = "Number"
-	fieldNameLength   = "Length"
-	fieldNameName     = "Name"
+	fieldNameType        = "Type"
+	fieldNameFilename    = "Filename"
+	fieldNameArtist      = "Artist"
+	fieldNameAlbum       = "Album"
+	fieldNameTitle       = "Title"
+	fieldNameNumber      = "Number"
+	fieldNameLength      = "Length"
+	fieldNameName        = "Name"
+	fieldNameState       = "State"
+	fieldNamePlaylist    = "Playlist"
+	fieldNamePosition    = "Position"
+	fieldNameElapsed     = "Elapsed"
+	fieldNameSampleRate  = "SampleRate"
+	fieldNameChannels    = "Channels"
+	fieldNameChanged     = "Changed"
+	fieldNamePath        = "Path"
+	fieldNameEvent       = "Event"
+	fieldNameSubsystem   = "Subsystem"
+	fieldNameUsage       = "Usage"
+	fieldNameDescription = "Description"
+	fieldNamePermission  = "Permission"
+	fieldNameParameter   = "Parameter"
+	fieldNameOptional    = "Optional"
+	fieldNameVariadic    = "Variadic"
+	fieldNameId          = "Id"
+	fieldNameAddress     = "Address"
+	fieldNameConnected   = "Connected"
+	fieldNameCommand     = "Command"
+	fieldNameLevel       = "Level"
+	fieldNameKey         = "Key"
+	fieldNameValue       = "Value"
 )
 
+// jsonCommand is JSON-framed command representation:
+// {"command": "LS", "args": ["/"]}.
+type jsonCommand struct {
+	Command string
+	Args    []string
+}
+
 // parseCommand parses client's string command (request) to command object.
-// TODO: This parser sucks, so write you own with blackjac & bitches.
+// Request is either the text protocol line or JSON-framed command.
 func parseCommand(str string) (cmd *command, err os.Error) {
+	if strings.HasPrefix(strings.TrimSpace(str), "{") {
+		return parseJSONCommand(str)
+	}
+
+	tokens, err := utils.Tokenize(str)
+	if err != nil {
+		return nil, newError(errorCodeSyntax, "%s", err)
+	}
+
 	cmd = new(command)
+	cmd.Parameters = make([]string, 0)
+	if len(tokens) > 0 {
+		cmd.Name = tokens[0]
+		cmd.Parameters = tokens[1:]
+	}
 
-	var s scanner.Scanner
-	s.Init(strings.NewReader(str))
+	return cmd, nil
+}
 
-	// Command name.
-	tok := s.Scan()
-	if tok != scanner.EOF {
-		cmd.Name = strings.Trim(s.TokenText(), "\"")
+// parseJSONCommand parses JSON-framed command.
+func parseJSONCommand(str string) (cmd *command, err os.Error) {
+	var jsonCmd jsonCommand
+	err = json.Unmarshal([]byte(str), &jsonCmd)
+	if err != nil {
+		return nil, newError(errorCodeSyntax, "Broken JSON command. %s", err)
 	}
 
-	// Parameters.
-	cmd.Parameters = make([]string, 0)
-	tok = s.Scan()
-	for tok != scanner.EOF {
-		cmd.Parameters = append(cmd.Parameters, strings.Trim(s.TokenText(), "\""))
-		tok = s.Scan()
+	cmd = new(command)
+	cmd.Name = jsonCmd.Command
+	cmd.Parameters = jsonCmd.Args
+	if cmd.Parameters == nil {
+		cmd.Parameters = make([]string, 0)
 	}
 
 	return cmd, nil
 }
 
 // CommandHandler signature.
-type commandHandler func(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error
+type commandHandler func(ch *CommandHandler, resp *response, cmd *command) os.Error
+
+// Parameter types. Parameters are converted before command execution:
+// string - string, int - non-negative int, path - *vfs.Path,
+// track - *trackRef, playlist - *playlist.Playlist, duration - *timeOffset.
+const (
+	paramTypeString   = "string"
+	paramTypeInt      = "int"
+	paramTypePath     = "path"
+	paramTypeTrack    = "track"
+	paramTypePlaylist = "playlist"
+	paramTypeDuration = "duration"
+)
+
+// param describes one command parameter.
+type param struct {
+	// Parameter name.
+	name string
+	// Parameter type (one of paramType* constants).
+	t string
+	// true if parameter can be omitted.
+	optional bool
+	// true if parameter can be repeated. Only the last parameter can be variadic.
+	variadic bool
+}
 
 // commandDescriptor describes command attribetes (parameters, handler function, ...)
 type commandDescriptor struct {
-	// Number of required arguments.
-	argc int
+	// Command parameters.
+	params []param
+	// Short description of the command.
+	description string
 	// Handler function for the command.
 	handler commandHandler
+	// true if command can block for a long time. Such commands are not executed
+	// under the player lock and can't be used in command lists.
+	blocking bool
+	// Permission level required to execute the command.
+	permission auth.Level
+}
+
+// arity returns minimal and maximal number of the command parameters.
+// max is -1 if command accepts any number of parameters.
+func (descriptor *commandDescriptor) arity() (min int, max int) {
+	for _, p := range descriptor.params {
+		if !p.optional {
+			min++
+		}
+		if p.variadic {
+			max = -1
+		} else if max != -1 {
+			max++
+		}
+	}
+
+	return min, max
+}
+
+// usage returns command synopsis, e. g. "ADD playlist track...".
+// Optional parameters are enclosed in square brackets.
+func (descriptor *commandDescriptor) usage(name string) string {
+	buf := bytes.NewBufferString(name)
+	for _, p := range descriptor.params {
+		s := p.name
+		if p.variadic {
+			s += "..."
+		}
+		if p.optional {
+			s = "[" + s + "]"
+		}
+		buf.WriteString(" " + s)
+	}
+
+	return buf.String()
+}
+
+// trackRef is the converted track parameter: filename[:track_number].
+// Number is 0 for single track files and directories.
+type trackRef struct {
+	filename *vfs.Path
+	number   int
+}
+
+// timeOffset is the converted duration parameter: [+-]seconds or [+-]m:ss.
+type timeOffset struct {
+	seconds int
+	// true if seconds is an offset from the current position.
+	relative bool
+}
+
+// convert converts parameter value according to its type.
+func (ch *CommandHandler) convert(p param, value string) (arg interface{}, err os.Error) {
+	switch p.t {
+	case paramTypeString:
+		return value, nil
+	case paramTypeInt:
+		return parsePosition(value)
+	case paramTypePath:
+		return ch.fs.Resolve(value), nil
+	case paramTypeTrack:
+		return ch.parseTrackRef(value)
+	case paramTypePlaylist:
+		return player.Playlist(value)
+	case paramTypeDuration:
+		return parseDuration(value)
+	}
+
+	return nil, os.NewError(fmt.Sprintf("Unknown parameter type '%s'", p.t))
+}
+
+// convertArgs converts command parameters according to the descriptor.
+// Values of the variadic parameter are converted with its type.
+func (ch *CommandHandler) convertArgs(descriptor *commandDescriptor, cmd *command) os.Error {
+	cmd.Args = make([]interface{}, 0, len(cmd.Parameters))
+	for i, value := range cmd.Parameters {
+		p := descriptor.params[len(descriptor.params)-1]
+		if i < len(descriptor.params) {
+			p = descriptor.params[i]
+		}
+
+		arg, err := ch.convert(p, value)
+		if err != nil {
+			return newError(errorCodeArgument, "Bad parameter '%s' of '%s'. %s", p.name, cmd.Name, err)
+		}
+		cmd.Args = append(cmd.Args, arg)
+	}
+
+	return nil
 }
 
-// All supported commands descriptors. 
-var commandDescriptors = map[string]commandDescriptor{
-	"CD":             commandDescriptor{1, cmdCd},
-	"LS":             commandDescriptor{0, cmdLs},
-	"PING":           commandDescriptor{0, cmdPing},
-	"PWD":            commandDescriptor{0, cmdPwd},
-	"PLAYLISTS":      commandDescriptor{0, cmdPlaylists},
-	"ADDPLAYLIST":    commandDescriptor{1, cmdAddPlaylist},
-	"DELETEPLAYLIST": commandDescriptor{1, cmdDeletePlaylist},
-	"PLAYVFS":        commandDescriptor{1, cmdPlayVfs},
-	"PAUSE":          commandDescriptor{0, cmdPause},
-	"KILL":           commandDescriptor{0, cmdKill},
-	// "QUIT": built-in
+// KILL command requests are sent to this channel.
+var killRequests = make(chan bool, 1)
+
+// KillRequests returns channel which recieves a value when client
+// requests daemon termination with the KILL command.
+func KillRequests() <-chan bool {
+	return killRequests
 }
 
+// All supported commands descriptors. Map is filled in init(), because
+// COMMANDS and HELP handlers refer to it.
+// "QUIT", "BEGIN", "BEGIN_OK", "END" and "STARTTLS" are built-in commands.
+var commandDescriptors map[string]commandDescriptor
+
 // CommandHandler struct.
 type CommandHandler struct {
+	// IDLE subscription and command list (BEGIN ... END) state.
+	// Session's Close implements server.Closer interface.
+	*session.Session
 	fs *vfs.Filesystem
+	// Responses format: formatText or formatJSON.
+	format int
+	// Permission level of the connection.
+	level auth.Level
+	// Client address failed passwords are counted for.
+	remoteAddr string
+	// true if connection can be upgraded to TLS with STARTTLS.
+	upgradable bool
+	// true if connection is secured with TLS.
+	secure bool
+	// true if STARTTLS was accepted and connection is about to be upgraded.
+	upgrading bool
 }
 
 // NewCommandHandler creates new initialized command handler object.
 func NewCommandHandler() *CommandHandler {
 	ch := new(CommandHandler)
+	ch.Session = session.New()
 	ch.fs = vfs.New()
+	ch.level = auth.DefaultLevel()
 
 	return ch
 }
 
-// HandleCommand interface implementation which will be called on every client's request
-func (ch *CommandHandler) HandleCommand(writer *bufio.Writer, request string) bool {
-	writeOk := func() {
-		writer.WriteString("OK\n")
-		writer.Flush()
+// Interrupt implements server.Interruptible interface.
+// NOIDLE command recieved while IDLE is running cancels it. NOIDLE
+// recieved while other command is running is handled as usual one.
+func (ch *CommandHandler) Interrupt(running string, request string) bool {
+	cmd, err := parseCommand(running)
+	if err != nil || cmd.Name != "IDLE" {
+		return false
+	}
+	cmd, err = parseCommand(request)
+	if err != nil || cmd.Name != "NOIDLE" {
+		return false
 	}
 
-	writeError := func(err os.Error) {
-		writer.WriteString(fmt.Sprintf("ERROR %s\n", err))
-		writer.Flush()
+	ch.CancelIdle()
+
+	return true
+}
+
+// positionEvents sends position events to all subscribed clients. Player status
+// is requested once a second for all of them and only while somebody listens.
+type positionEvents struct {
+	subscribers map[chan *record]bool
+	// true if the ticking goroutine is running.
+	running bool
+	// Mutex for protecting all above.
+	mutex sync.Mutex
+}
+
+// Position events of the event sources.
+var positionFeed = &positionEvents{subscribers: make(map[chan *record]bool)}
+
+// subscribe returns channel position events are sent to.
+func (feed *positionEvents) subscribe() chan *record {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	// Slow client skips events instead of blocking others.
+	sub := make(chan *record, 1)
+	feed.subscribers[sub] = true
+	if !feed.running {
+		feed.running = true
+		go feed.run()
 	}
 
+	return sub
+}
+
+// unsubscribe stops sending events to the channel.
+func (feed *positionEvents) unsubscribe(sub chan *record) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.subscribers[sub] = false, false
+}
+
+// run sends position event every second while track is being played.
+// It exits when the last subscriber is gone.
+func (feed *positionEvents) run() {
+	ticker := time.NewTicker(1e9)
+	defer ticker.Stop()
+
+	for _ = range ticker.C {
+		feed.mutex.Lock()
+		if len(feed.subscribers) == 0 {
+			feed.running = false
+			feed.mutex.Unlock()
+			return
+		}
+		feed.mutex.Unlock()
+
+		status := player.CurrentStatus()
+		if status.State != "playing" {
+			continue
+		}
+
+		rec := new(record)
+		rec.Set(fieldNameEvent, "position")
+		rec.Set(fieldNameElapsed, duration(status.Elapsed))
+		rec.Set(fieldNameLength, duration(status.Length))
+
+		feed.mutex.Lock()
+		for sub := range feed.subscribers {
+			select {
+			case sub <- rec:
+			default:
+			}
+		}
+		feed.mutex.Unlock()
+	}
+}
+
+// Events implements server.EventSource interface. JSON documents are sent
+// to the channel for every changed subsystem:
+// {"Event": "changed", "Subsystem": "playlist"}
+// and every second while track is being played:
+// {"Event": "position", "Elapsed": 42, "Length": 180}
+// Channel is closed after quit is closed.
+func (ch *CommandHandler) Events(quit <-chan bool) <-chan string {
+	events := make(chan string)
+	changes := make(chan []string)
+
+	// Subsystems changes are collected with the own listener to
+	// not interfere with the IDLE command.
+	listener := event.Subscribe()
+	go func() {
+		defer listener.Unsubscribe()
+		for {
+			changed := listener.Wait(event.Subsystems, quit)
+			if changed == nil {
+				return
+			}
+
+			select {
+			case changes <- changed:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(events)
+
+		positions := positionFeed.subscribe()
+		defer positionFeed.unsubscribe(positions)
+
+		for {
+			docs := make([]*record, 0)
+			select {
+			case changed := <-changes:
+				for _, subsystem := range changed {
+					rec := new(record)
+					rec.Set(fieldNameEvent, "changed")
+					rec.Set(fieldNameSubsystem, subsystem)
+					docs = append(docs, rec)
+				}
+			case rec := <-positions:
+				docs = append(docs, rec)
+			case <-quit:
+				return
+			}
+
+			for _, rec := range docs {
+				buf := new(bytes.Buffer)
+				writeJSONRecord(buf, rec)
+				select {
+				case events <- buf.String():
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// Level returns permission level of the connection.
+func (ch *CommandHandler) Level() auth.Level {
+	return ch.level
+}
+
+// NotifyShutdown implements server.ShutdownNotifier interface.
+func (ch *CommandHandler) NotifyShutdown(writer *bufio.Writer) {
+	rec := new(record)
+	rec.Set(fieldNameEvent, "shutdown")
+
+	if ch.format == formatJSON {
+		buf := new(bytes.Buffer)
+		writeJSONRecord(buf, rec)
+		buf.WriteString("\n")
+		writer.Write(buf.Bytes())
+	} else {
+		writeTextRecords(writer, []*record{rec})
+	}
+}
+
+// IsUpgrade implements server.Upgrader interface.
+func (ch *CommandHandler) IsUpgrade(request string) bool {
+	cmd, err := parseCommand(request)
+
+	return err == nil && cmd.Name == "STARTTLS"
+}
+
+// UpgradeAccepted implements server.Upgrader interface.
+func (ch *CommandHandler) UpgradeAccepted() bool {
+	accepted := ch.upgrading
+	ch.upgrading = false
+
+	return accepted
+}
+
+// Upgraded implements server.Upgrader interface. Client certificate
+// can raise permission level of the connection.
+func (ch *CommandHandler) Upgraded(state tls.ConnectionState) {
+	ch.secure = true
+
+	level, ok := auth.CertificateLevel(state.PeerCertificates)
+	if ok && level > ch.level {
+		ch.level = level
+	}
+}
+
+// startTLS accepts STARTTLS request. TLS handshake is done by server
+// right after OK response is sent.
+func (ch *CommandHandler) startTLS(cmd *command) os.Error {
+	if len(cmd.Parameters) != 0 {
+		return newError(errorCodeArgument, "STARTTLS has no parameters")
+	}
+	if server.TLSConfig() == nil {
+		return newError(errorCodeFailed, "TLS isn't configured")
+	}
+	if ch.secure {
+		return newError(errorCodeFailed, "Connection is already secured")
+	}
+	if !ch.upgradable {
+		return newError(errorCodeFailed, "Connection can't be upgraded")
+	}
+
+	ch.upgrading = true
+
+	return nil
+}
+
+// HandleCommand interface implementation which will be called on every client's request
+func (ch *CommandHandler) HandleCommand(writer *bufio.Writer, request string) bool {
 	cmd, err := parseCommand(request)
 	if err != nil {
-		writeError(err)
+		// Broken command list can't be executed.
+		ch.AbortList()
+		ch.writeResponse(writer, nil, err)
+		return false
+	}
+
+	// Commands of the list are executed all together when END recieved.
+	if ch.InList() {
+		if cmd.Name == "END" {
+			ch.runList(writer, ch.EndList())
+		} else {
+			ch.AddToList(cmd)
+		}
+
 		return false
 	}
 
-	// Check if it is built-in QUIT command.
-	if cmd.Name == "QUIT" {
-		writeOk()
+	switch cmd.Name {
+	case "QUIT":
+		// Check if it is built-in QUIT command.
+		ch.writeResponse(writer, nil, nil)
 		return true
+	case "BEGIN", "BEGIN_OK":
+		ch.BeginList(cmd.Name == "BEGIN_OK")
+		return false
+	case "END":
+		ch.writeResponse(writer, nil, newError(errorCodeList, "END without BEGIN"))
+		return false
+	case "STARTTLS":
+		ch.writeResponse(writer, nil, ch.startTLS(cmd))
+		return false
 	}
 
-	err = ch.run(writer, cmd)
+	resp := newResponse()
+	err = ch.execute(resp, cmd)
+
+	// NOIDLE consumed while IDLE was finishing by itself has no effect.
+	ch.DropCancelIdle()
+
 	if err == nil {
-		writeOk()
+		ch.writeResponse(writer, resp, nil)
 	} else {
-		writeError(err)
+		ch.writeResponse(writer, nil, err)
 	}
 
 	return false
 }
 
+// execute runs single command. Non-blocking commands are executed under the player lock.
+func (ch *CommandHandler) execute(resp *response, cmd *command) os.Error {
+	return session.Execute(isBlocking(cmd), func() os.Error {
+		return ch.run(resp, cmd)
+	})
+}
+
+// runList executes recieved command list. All commands are executed
+// under the player lock, so no other client can interfere. Execution is stopped
+// at the first failed command.
+func (ch *CommandHandler) runList(writer *bufio.Writer, list []interface{}) {
+	resps := make([]*response, 0, len(list))
+	err := session.RunList(list, func(i int, c interface{}) os.Error {
+		cmd := c.(*command)
+		resp := newResponse()
+		var err os.Error
+		if isBlocking(cmd) {
+			err = newError(errorCodeList, "Command '%s' can't be used in command list", cmd.Name)
+		} else {
+			err = ch.run(resp, cmd)
+		}
+		if err != nil {
+			perr := toProtocolError(err)
+			perr.index = i
+			perr.command = cmd.Name
+			return perr
+		}
+
+		resps = append(resps, resp)
+
+		return nil
+	})
+
+	if !ch.ListOk() {
+		combined := newResponse()
+		for _, resp := range resps {
+			combined.Append(resp)
+		}
+		resps = []*response{combined}
+	}
+
+	if ch.format == formatJSON {
+		if ch.ListOk() {
+			writeJSONList(writer, resps, err)
+		} else {
+			writeJSON(writer, resps[0], err)
+		}
+	} else {
+		for _, resp := range resps {
+			writeTextRecords(writer, resp.records)
+			if ch.ListOk() {
+				writer.WriteString("LIST_OK\n")
+			}
+		}
+		if err != nil {
+			writeTextError(writer, err)
+		} else {
+			writer.WriteString("OK\n")
+		}
+	}
+	writer.Flush()
+}
+
+// writeResponse writes command result in the format chosen by client.
+func (ch *CommandHandler) writeResponse(writer *bufio.Writer, resp *response, err os.Error) {
+	if ch.format == formatJSON {
+		writeJSON(writer, resp, err)
+	} else if err != nil {
+		writeTextError(writer, err)
+	} else {
+		if resp != nil {
+			writeTextRecords(writer, resp.records)
+		}
+		writer.WriteString("OK\n")
+	}
+	writer.Flush()
+}
+
+// isBlocking returns true if command can block for a long time.
+func isBlocking(cmd *command) bool {
+	cmdDescriptor, ok := commandDescriptors[cmd.Name]
+
+	return ok && cmdDescriptor.blocking
+}
+
 // run multiplex all handlers and select related to be invoked.
-func (ch *CommandHandler) run(writer *bufio.Writer, cmd *command) os.Error {
+func (ch *CommandHandler) run(resp *response, cmd *command) os.Error {
 	// Check if command is supported.
 	cmdDescriptor, ok := commandDescriptors[cmd.Name]
 	if !ok {
-		return os.NewError(fmt.Sprintf("Unsupported command '%s'", cmd.Name))
+		return newError(errorCodeCommand, "Unsupported command '%s'", cmd.Name)
 	}
 
 	// Check if number of parameters are correct.
-	if cmdDescriptor.argc != len(cmd.Parameters) {
-		return os.NewError(fmt.Sprintf("Command '%s' requires %d parameters but %d given",
-			cmd.Name, cmdDescriptor.argc, len(cmd.Parameters)))
+	min, max := cmdDescriptor.arity()
+	if len(cmd.Parameters) < min || (max != -1 && len(cmd.Parameters) > max) {
+		return newError(errorCodeArgument, "Wrong number of parameters for '%s'. Usage: %s",
+			cmd.Name, cmdDescriptor.usage(cmd.Name))
+	}
+
+	// Check if client is allowed to execute the command.
+	if cmdDescriptor.permission > ch.level {
+		return newError(errorCodePermission, "Command '%s' requires '%s' permission",
+			cmd.Name, cmdDescriptor.permission)
+	}
+
+	err := ch.convertArgs(&cmdDescriptor, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = cmdDescriptor.handler(ch, resp, cmd)
+	if err != nil {
+		log.Debug("Command '%s' failed. %s", cmd.Name, err)
 	}
 
-	return cmdDescriptor.handler(ch, writer, cmd)
+	return err
 }
 
 // ConnectionHandler implementation type.
@@ -154,20 +696,41 @@ type ConnectionHandler func(conn net.Conn) CommandHandler
 
 // HandleConnection handle every client's connection.
 func (ch ConnectionHandler) HandleConnection(conn net.Conn) server.CommandHandler {
-	return NewCommandHandler()
+	handler := NewCommandHandler()
+	if addr := conn.RemoteAddr(); addr != nil {
+		handler.remoteAddr = addr.String()
+	}
+	// Only plain TCP connections can be upgraded to TLS.
+	_, handler.upgradable = conn.(*net.TCPConn)
+
+	return handler
+}
+
+// setTrack sets track description fields.
+func setTrack(rec *record, track *vfs.Track) {
+	tag := track.Tag
+	// Tracks are indentified by filename:trackNum scheme.
+	// For single track files trackNum is 0.
+	rec.Set(fieldNameFilename, fmt.Sprintf("%s:%d", track.FilePath.Path(), track.Number))
+	rec.Set(fieldNameArtist, tag.Artist)
+	rec.Set(fieldNameAlbum, tag.Album)
+	rec.Set(fieldNameTitle, tag.Title)
+	rec.Set(fieldNameNumber, tagNumber(tag.Number))
+	rec.Set(fieldNameLength, duration(track.Len()))
 }
 
 // cmdPing implements PING command.
 // PING command just does nothing.
-func cmdPing(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+func cmdPing(ch *CommandHandler, resp *response, cmd *command) os.Error {
 	return nil
 }
 
 // cmdPwd implemets PWD command.
 // PWD command prints current working directory.
-func cmdPwd(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	writer.WriteString(ch.fs.WorkingDir())
-	writer.WriteString("\n")
+func cmdPwd(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	rec := resp.NewRecord()
+	rec.bare = true
+	rec.Set(fieldNamePath, ch.fs.WorkingDir())
 
 	return nil
 }
@@ -176,51 +739,39 @@ func cmdPwd(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
 // CD changes current working directory.
 // Parameters:
 // * directory
-func cmdCd(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	dir := cmd.Parameters[0]
-
-	return ch.fs.SetWorkingDir(dir)
+func cmdCd(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	dir := cmd.Args[0].(*vfs.Path)
 
+	return ch.fs.SetWorkingDir(dir.Path())
 }
 
 // cmdLs implements LS server command.
-// LS command prints sorted (dirs before files) working direcory listing.
-func cmdLs(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	// Write HTTP header-like string to writer.
-	// Key: Value
-	writePair := func(key string, value string) {
-		writer.WriteString(fmt.Sprintf("%s: %s\n", key, value))
+// LS command prints sorted (dirs before files) direcory listing.
+// Parameters:
+// * optional directory, working directory is listed if omitted
+func cmdLs(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	var entries []*vfs.Entry
+	var err os.Error
+	if len(cmd.Args) > 0 {
+		entries, err = ch.fs.ListDir(cmd.Args[0].(*vfs.Path))
+	} else {
+		entries, err = ch.fs.List()
 	}
-
-	entries, err := ch.fs.List()
 	if err != nil {
 		return err
 	}
 
-	lastIndex := len(entries) - 1
-	for i := 0; i < len(entries); i++ {
-		writePair(fieldNameType, entries[i].TypeString())
+	for _, entry := range entries {
+		rec := resp.NewRecord()
+		rec.Set(fieldNameType, entry.TypeString())
 
-		switch entries[i].Type() {
+		switch entry.Type() {
 		case vfs.TypeTrack:
-			track := entries[i].Track()
-			tag := track.Tag
-			// Tracks are indentified by filename:trackNum scheme.
-			// For single track files trackNum is 0.
-			writePair(fieldNameFilename, fmt.Sprintf("%s:%d", track.FilePath.Path(), track.Number))
-			writePair(fieldNameArtist, tag.Artist)
-			writePair(fieldNameAlbum, tag.Album)
-			writePair(fieldNameTitle, tag.Title)
-			writePair(fieldNameNumber, tag.Number)
-			writePair(fieldNameLength, tag.Length)
+			setTrack(rec, entry.Track())
 		case vfs.TypeDirectory:
-			dir := entries[i].Directory()
-			writePair(fieldNameFilename, dir.Filename.Path())
-			writePair(fieldNameName, dir.Name)
-		}
-
-		if i < lastIndex {
-			writer.WriteString("\n")
+			dir := entry.Directory()
+			rec.Set(fieldNameFilename, dir.Filename.Path())
+			rec.Set(fieldNameName, dir.Name)
 		}
 	}
 
@@ -229,21 +780,16 @@ func cmdLs(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
 
 // cmdPlaylists handles PLAYLISTS server command.
 // PLAYLISTS command prints list of the registered playlists.
-func cmdPlaylists(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	lastIndex := len(player.Playlists()) - 1
-
-	for i, pl := range player.Playlists() {
+func cmdPlaylists(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	for _, pl := range player.Playlists() {
 		// System playlists are not visible to clients.
 		if pl.IsSystem() {
 			continue
 		}
 
-		writer.WriteString(fmt.Sprintf("Name: %s\n", pl.Name()))
-		writer.WriteString(fmt.Sprintf("Length: %d\n", pl.Len()))
-
-		if i < lastIndex {
-			writer.WriteString("\n")
-		}
+		rec := resp.NewRecord()
+		rec.Set(fieldNameName, pl.Name())
+		rec.Set(fieldNameLength, pl.Len())
 	}
 
 	return nil
@@ -252,8 +798,8 @@ func cmdPlaylists(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Err
 // cmdAddPlaylist creates new empty playlist.
 // Parameters:
 // * playlist name
-func cmdAddPlaylist(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	name := cmd.Parameters[0]
+func cmdAddPlaylist(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	name := cmd.Args[0].(string)
 
 	return player.AddPlaylist(name)
 }
@@ -261,29 +807,183 @@ func cmdAddPlaylist(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.E
 // cmdDeletePlaylist deletes existing playlist for the given name.
 // Parameters:
 // * playlist name
-func cmdDeletePlaylist(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	name := cmd.Parameters[0]
+func cmdDeletePlaylist(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
 
-	return player.DeletePlaylist(name)
+	return player.DeletePlaylist(pl.Name())
 }
 
-// cmdPlayVfs plays track from the working directory.
-// Parameters:
-// * filename in next format: file.flac:3
-func cmdPlayVfs(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
-	param := cmd.Parameters[0]
-	i := strings.LastIndex(param, ":")
+// parseTrackRef parses track reference in filename[:track_number] format.
+// Filename can be given relatively to the working directory. If the text
+// after the last colon isn't a number, the colon is a part of the filename.
+func (ch *CommandHandler) parseTrackRef(ref string) (track *trackRef, err os.Error) {
+	i := strings.LastIndex(ref, ":")
 	if i == -1 {
-		return os.NewError("Bad filename format. Expected filename:track_number")
+		return &trackRef{ch.fs.Resolve(ref), 0}, nil
+	}
+
+	number, err := strconv.Atoi(ref[i+1:])
+	if err != nil {
+		return &trackRef{ch.fs.Resolve(ref), 0}, nil
+	}
+	if number < 0 {
+		return nil, os.NewError("Bad track number format. 0..99 expected")
 	}
 
-	filename := param[:i]
-	trackNumber, err := strconv.Atoi(param[i + 1:])
-	fmt.Printf("trackNumber: %d\n\n", trackNumber)
-	if err != nil || trackNumber < 0 {
-		return os.NewError("Bad track number format. 0..99 expected")
+	return &trackRef{ch.fs.Resolve(ref[:i]), number}, nil
+}
+
+// parsePosition parses track position in a playlist.
+func parsePosition(str string) (pos int, err os.Error) {
+	pos, err = strconv.Atoi(str)
+	if err != nil || pos < 0 {
+		return 0, os.NewError(fmt.Sprintf("Non-negative number expected instead of '%s'", str))
+	}
+
+	return pos, nil
+}
+
+// parseDuration parses time in seconds or m:ss format. Time prefixed
+// with + or - is the offset from the current position.
+func parseDuration(str string) (offset *timeOffset, err os.Error) {
+	offset = new(timeOffset)
+	sign := 1
+	if strings.HasPrefix(str, "+") || strings.HasPrefix(str, "-") {
+		offset.relative = true
+		if str[0] == '-' {
+			sign = -1
+		}
+		str = str[1:]
+	}
+
+	badFormat := os.NewError("Bad time format. [+-]seconds or [+-]m:ss expected")
+	parts := strings.Split(str, ":")
+	if len(parts) > 2 {
+		return nil, badFormat
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, badFormat
+		}
+		offset.seconds = offset.seconds*60 + n
+	}
+	offset.seconds *= sign
+
+	return offset, nil
+}
+
+// checkUserPlaylist returns error if playlist content can't be modified by client.
+func checkUserPlaylist(pl *playlist.Playlist) os.Error {
+	if pl.IsSystem() {
+		return os.NewError("System playlist can't be modified")
+	}
+
+	return nil
+}
+
+// cmdPlaylist prints tracks of the playlist in the LS command format.
+// Parameters:
+// * playlist name
+func cmdPlaylist(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
+
+	for _, track := range pl.Tracks() {
+		rec := resp.NewRecord()
+		rec.Set(fieldNameType, vfs.NewEntry(vfs.TypeTrack, track).TypeString())
+		setTrack(rec, track)
+	}
+
+	return nil
+}
+
+// cmdAdd appends tracks to the end of the playlist.
+// Parameters:
+// * playlist name
+// * tracks in the filename:track_number format or directories.
+//   All tracks of the directory and its subdirectories are added.
+func cmdAdd(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
+	err := checkUserPlaylist(pl)
+	if err != nil {
+		return err
+	}
+
+	// Tracks are appended only if all of them are found.
+	tracks := make([]*vfs.Track, 0, len(cmd.Args)-1)
+	for _, arg := range cmd.Args[1:] {
+		ref := arg.(*trackRef)
+
+		if ch.fs.IsDirectory(ref.filename) {
+			dirTracks, err := ch.fs.TracksRecursive(ref.filename)
+			if err != nil {
+				return err
+			}
+			tracks = append(tracks, dirTracks...)
+			continue
+		}
+
+		track, err := ch.fs.Track(ref.filename, ref.number)
+		if err != nil {
+			return err
+		}
+		tracks = append(tracks, track)
+	}
+	pl.Append(tracks...)
+
+	return nil
+}
+
+// cmdRemove removes track from the playlist.
+// Parameters:
+// * playlist name
+// * track position
+func cmdRemove(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
+	err := checkUserPlaylist(pl)
+	if err != nil {
+		return err
+	}
+
+	return player.RemoveTrack(pl.Name(), cmd.Args[1].(int))
+}
+
+// cmdMove moves track inside the playlist.
+// Parameters:
+// * playlist name
+// * current track position
+// * new track position
+func cmdMove(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
+	err := checkUserPlaylist(pl)
+	if err != nil {
+		return err
+	}
+
+	return player.MoveTrack(pl.Name(), cmd.Args[1].(int), cmd.Args[2].(int))
+}
+
+// cmdPlay plays track from the playlist.
+// Parameters:
+// * playlist name
+// * optional track position, first track is played if omitted
+func cmdPlay(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	pl := cmd.Args[0].(*playlist.Playlist)
+	pos := 0
+	if len(cmd.Args) > 1 {
+		pos = cmd.Args[1].(int)
 	}
 
+	return player.Play(pl.Name(), pos)
+}
+
+// cmdPlayVfs plays track from the working directory.
+// Parameters:
+// * filename in next format: file.flac:3
+func cmdPlayVfs(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	ref := cmd.Args[0].(*trackRef)
+	filename := ref.filename.Path()
+
 	pl, _ := player.Playlist(vfs.PlaylistName) // I don't check error, because this playlist should be present always.
 	pl.Clear()
 
@@ -301,32 +1001,463 @@ func cmdPlayVfs(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error
 	// Find track position in the VFS playlist.
 	pos := -1
 	for i, track := range pl.Tracks() {
-		if track.Number == trackNumber && track.FilePath.Path() == filename {
+		if track.Number == ref.number && track.FilePath.Path() == filename {
 			pos = i
 		}
 	}
 	if pos == -1 {
 		return os.NewError("Track not found in working directory")
 	}
-	
-	player.Play(vfs.PlaylistName, pos)
 
-	return nil
+	return player.Play(vfs.PlaylistName, pos)
 }
 
 // cmdPause toggle player's pause state.
-func cmdPause(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+func cmdPause(ch *CommandHandler, resp *response, cmd *command) os.Error {
 	player.Pause()
 
 	return nil
 }
 
-// cmdKill stops player. After program can be terminated.
-func cmdKill(ch *CommandHandler, writer *bufio.Writer, cmd *command) os.Error {
+// cmdStop stops playing.
+func cmdStop(ch *CommandHandler, resp *response, cmd *command) os.Error {
 	player.Stop()
 
-	// TOOD: Add here correct program termination.
-	panic("TOOD: Add here correct program termination")
+	return nil
+}
+
+// cmdNext switches player to the next track in the current playlist.
+func cmdNext(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	player.Next()
+
+	return nil
+}
+
+// cmdPrevious switches player to the previous track in the current playlist.
+func cmdPrevious(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	player.Previous()
 
 	return nil
 }
+
+// cmdSeek changes playing position in the current track.
+// Parameters:
+// * position in seconds (or m:ss) from the beginning of the track,
+//   or offset from the current position if prefixed with + or -
+func cmdSeek(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	offset := cmd.Args[0].(*timeOffset)
+
+	return player.Seek(offset.seconds, offset.relative)
+}
+
+// cmdStatus implements STATUS command.
+// STATUS command prints current player state and information
+// about the track is being played.
+func cmdStatus(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	status := player.CurrentStatus()
+
+	rec := resp.NewRecord()
+	rec.Set(fieldNameState, status.State)
+	if status.Track != nil {
+		rec.Set(fieldNamePlaylist, status.Playlist)
+		rec.Set(fieldNamePosition, status.Position)
+		track := status.Track
+		rec.Set(fieldNameFilename, fmt.Sprintf("%s:%d", track.FilePath.Path(), track.Number))
+		rec.Set(fieldNameArtist, track.Tag.Artist)
+		rec.Set(fieldNameAlbum, track.Tag.Album)
+		rec.Set(fieldNameTitle, track.Tag.Title)
+		rec.Set(fieldNameElapsed, duration(status.Elapsed))
+		rec.Set(fieldNameLength, duration(status.Length))
+		rec.Set(fieldNameSampleRate, status.SampleRate)
+		rec.Set(fieldNameChannels, status.Channels)
+	}
+
+	return nil
+}
+
+// cmdIdle waits till any of the given subsystems is changed and prints changed ones.
+// All subsystems are watched if no parameters given. Waiting can be canceled
+// with NOIDLE command.
+// Parameters:
+// * subsystem names (player, track, playlists, playlist, library)
+func cmdIdle(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	subsystems := make([]string, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		subsystems = append(subsystems, arg.(string))
+	}
+	if len(subsystems) == 0 {
+		subsystems = event.Subsystems
+	}
+	for _, s := range subsystems {
+		if !event.IsSubsystem(s) {
+			return newError(errorCodeArgument, "Unknown subsystem '%s'", s)
+		}
+	}
+
+	changed := ch.Listener.Wait(subsystems, ch.NoIdle)
+	if len(changed) > 0 {
+		resp.NewRecord().Set(fieldNameChanged, changed)
+	}
+
+	return nil
+}
+
+// cmdFormat sets responses format for the current connection.
+// Parameters:
+// * format name: text or json
+func cmdFormat(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	format := cmd.Args[0].(string)
+	switch format {
+	case "text":
+		ch.format = formatText
+	case "json":
+		ch.format = formatJSON
+	default:
+		return newError(errorCodeArgument, "Unknown format '%s'. text or json expected", format)
+	}
+
+	return nil
+}
+
+// cmdPassword authenticates the client. Permission level assigned to
+// the password is granted to the connection.
+// Parameters:
+// * password
+func cmdPassword(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	level, err := auth.Authenticate(cmd.Args[0].(string), ch.remoteAddr)
+	if err != nil {
+		log.Warning("Authentication failed. %s", err)
+		return newError(errorCodePermission, "%s", err)
+	}
+
+	ch.level = level
+
+	return nil
+}
+
+// cmdKill requests daemon termination. Shutdown itself is done by the
+// program main loop, which listens KillRequests channel.
+func cmdKill(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	log.Info("Termination is requested with KILL command")
+
+	select {
+	case killRequests <- true:
+	default:
+		// Termination is already requested.
+	}
+
+	return nil
+}
+
+// cmdCommands prints names of the commands available for the client.
+func cmdCommands(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	names := make([]string, 0, len(commandDescriptors))
+	for name, descriptor := range commandDescriptors {
+		if descriptor.permission <= ch.level {
+			names = append(names, name)
+		}
+	}
+	utils.StringArray(names).Sort()
+
+	for _, name := range names {
+		resp.NewRecord().Set(fieldNameName, name)
+	}
+
+	return nil
+}
+
+// cmdHelp prints command description and its parameters.
+// Parameters:
+// * command name
+func cmdHelp(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	name := strings.ToUpper(cmd.Args[0].(string))
+	descriptor, ok := commandDescriptors[name]
+	if !ok {
+		return newError(errorCodeArgument, "Unsupported command '%s'", name)
+	}
+
+	rec := resp.NewRecord()
+	rec.Set(fieldNameName, name)
+	rec.Set(fieldNameUsage, descriptor.usage(name))
+	rec.Set(fieldNameDescription, descriptor.description)
+	rec.Set(fieldNamePermission, descriptor.permission.String())
+
+	for _, p := range descriptor.params {
+		rec := resp.NewRecord()
+		rec.Set(fieldNameParameter, p.name)
+		rec.Set(fieldNameType, p.t)
+		rec.Set(fieldNameOptional, p.optional)
+		rec.Set(fieldNameVariadic, p.variadic)
+	}
+
+	return nil
+}
+
+// leveled is implemented by command handlers (of any protocol) which
+// have permission levels.
+type leveled interface {
+	Level() auth.Level
+}
+
+// cmdClients prints connected clients.
+func cmdClients(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	for _, client := range server.Clients() {
+		rec := resp.NewRecord()
+		rec.Set(fieldNameId, client.Id)
+		rec.Set(fieldNameAddress, client.RemoteAddr)
+		rec.Set(fieldNameConnected, time.SecondsToLocalTime(client.Connected/1e9).Format(time.RFC3339))
+		rec.Set(fieldNameCommand, maskPassword(client.LastCommand))
+		if handler, ok := client.Handler.(leveled); ok {
+			rec.Set(fieldNamePermission, handler.Level().String())
+		}
+	}
+
+	return nil
+}
+
+// maskPassword hides password of the PASSWORD command (of any protocol) and
+// secret value of the CONFIG SET command. Command is parsed, so text and JSON
+// forms are masked the same way. Only the first word of broken command is kept.
+func maskPassword(line string) string {
+	cmd, err := parseCommand(line)
+	if err != nil {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return ""
+		}
+		return fields[0] + " ***"
+	}
+
+	name := strings.ToUpper(cmd.Name)
+	params := cmd.Parameters
+	switch {
+	case name == "PASSWORD" && len(params) > 0:
+		return cmd.Name + " ***"
+	case name == "CONFIG" && len(params) > 2 && strings.ToUpper(params[0]) == "SET" && config.IsSecret(params[1]):
+		return fmt.Sprintf("%s %s %s ***", cmd.Name, params[0], params[1])
+	}
+
+	return line
+}
+
+// cmdKick disconnects the client.
+// Parameters:
+// * client id
+func cmdKick(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	return server.Kick(cmd.Args[0].(int))
+}
+
+// cmdLogLevel prints or changes log level. Level is changed for all subsystems
+// if subsystem isn't given.
+// Parameters:
+// * level (optional): error, warning, info or debug
+// * subsystem (optional)
+func cmdLogLevel(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	if len(cmd.Args) == 0 {
+		rec := resp.NewRecord()
+		rec.Set(fieldNameLevel, logger.GetLevel().String())
+
+		tagLevels := logger.TagLevels()
+		tags := make([]string, 0, len(tagLevels))
+		for tag := range tagLevels {
+			tags = append(tags, tag)
+		}
+		utils.StringArray(tags).Sort()
+		for _, tag := range tags {
+			rec := resp.NewRecord()
+			rec.Set(fieldNameSubsystem, tag)
+			rec.Set(fieldNameLevel, tagLevels[tag].String())
+		}
+
+		return nil
+	}
+
+	level, err := logger.ParseLevel(cmd.Args[0].(string))
+	if err != nil {
+		return newError(errorCodeArgument, "%s", err)
+	}
+
+	if len(cmd.Args) > 1 {
+		tag := cmd.Args[1].(string)
+		if !logger.IsTag(tag) {
+			return newError(errorCodeArgument, "Unknown subsystem '%s'", tag)
+		}
+		logger.SetTagLevel(tag, level)
+	} else {
+		logger.SetLevel(level)
+	}
+
+	return nil
+}
+
+// cmdConfig prints or changes configuration items. Secret values are masked,
+// items naming files daemon writes can be changed in the configuration file only.
+// Parameters:
+// * action: GET, SET or LIST
+// * key (for GET and SET)
+// * value (for SET)
+func cmdConfig(ch *CommandHandler, resp *response, cmd *command) os.Error {
+	action := strings.ToUpper(cmd.Args[0].(string))
+	switch {
+	case action == "LIST" && len(cmd.Args) == 1:
+		for _, key := range config.Configurations.Keys() {
+			setConfigItem(resp.NewRecord(), key)
+		}
+	case action == "GET" && len(cmd.Args) == 2:
+		key := cmd.Args[1].(string)
+		if _, err := config.Configurations.String(key); err != nil {
+			return newError(errorCodeArgument, "%s", err)
+		}
+		setConfigItem(resp.NewRecord(), key)
+	case action == "SET" && len(cmd.Args) == 3:
+		key := cmd.Args[1].(string)
+		err := config.Change(key, cmd.Args[2].(string))
+		if err != nil {
+			return newError(errorCodeArgument, "%s", err)
+		}
+		log.Info("Configuration item '%s' is changed", key)
+	default:
+		return newError(errorCodeArgument, "Usage: CONFIG LIST | CONFIG GET key | CONFIG SET key value")
+	}
+
+	return nil
+}
+
+// setConfigItem sets configuration item description fields.
+func setConfigItem(rec *record, key string) {
+	value, _ := config.Configurations.String(key)
+	if config.IsSecret(key) && value != "" {
+		value = "********"
+	}
+	rec.Set(fieldNameKey, key)
+	rec.Set(fieldNameValue, value)
+}
+
+// Package init function.
+func init() {
+	playlistParam := param{"playlist", paramTypePlaylist, false, false}
+
+	commandDescriptors = map[string]commandDescriptor{
+		"CD": commandDescriptor{
+			[]param{param{"directory", paramTypePath, false, false}},
+			"Change working directory.",
+			cmdCd, false, auth.LevelRead},
+		"LS": commandDescriptor{
+			[]param{param{"directory", paramTypePath, true, false}},
+			"List directory (working directory by default): directories first, then tracks.",
+			cmdLs, false, auth.LevelRead},
+		"PING": commandDescriptor{
+			[]param{},
+			"Do nothing.",
+			cmdPing, false, auth.LevelNone},
+		"PWD": commandDescriptor{
+			[]param{},
+			"Print working directory.",
+			cmdPwd, false, auth.LevelRead},
+		"PLAYLISTS": commandDescriptor{
+			[]param{},
+			"List playlists.",
+			cmdPlaylists, false, auth.LevelRead},
+		"ADDPLAYLIST": commandDescriptor{
+			[]param{param{"name", paramTypeString, false, false}},
+			"Create new empty playlist.",
+			cmdAddPlaylist, false, auth.LevelAdd},
+		"DELETEPLAYLIST": commandDescriptor{
+			[]param{playlistParam},
+			"Delete playlist.",
+			cmdDeletePlaylist, false, auth.LevelAdd},
+		"PLAYLIST": commandDescriptor{
+			[]param{playlistParam},
+			"List tracks of the playlist.",
+			cmdPlaylist, false, auth.LevelRead},
+		"ADD": commandDescriptor{
+			[]param{playlistParam, param{"track", paramTypeTrack, false, true}},
+			"Append tracks (filename:number) or all tracks of the directories to the playlist.",
+			cmdAdd, false, auth.LevelAdd},
+		"REMOVE": commandDescriptor{
+			[]param{playlistParam, param{"position", paramTypeInt, false, false}},
+			"Remove track from the playlist.",
+			cmdRemove, false, auth.LevelAdd},
+		"MOVE": commandDescriptor{
+			[]param{playlistParam, param{"from", paramTypeInt, false, false}, param{"to", paramTypeInt, false, false}},
+			"Move track inside the playlist.",
+			cmdMove, false, auth.LevelAdd},
+		"PLAY": commandDescriptor{
+			[]param{playlistParam, param{"position", paramTypeInt, true, false}},
+			"Play track from the playlist (the first one by default).",
+			cmdPlay, false, auth.LevelControl},
+		"PLAYVFS": commandDescriptor{
+			[]param{param{"track", paramTypeTrack, false, false}},
+			"Play track (filename:number) from the working directory.",
+			cmdPlayVfs, false, auth.LevelControl},
+		"PAUSE": commandDescriptor{
+			[]param{},
+			"Toggle pause.",
+			cmdPause, false, auth.LevelControl},
+		"STOP": commandDescriptor{
+			[]param{},
+			"Stop playing.",
+			cmdStop, false, auth.LevelControl},
+		"NEXT": commandDescriptor{
+			[]param{},
+			"Play next track of the current playlist.",
+			cmdNext, false, auth.LevelControl},
+		"PREVIOUS": commandDescriptor{
+			[]param{},
+			"Play previous track of the current playlist.",
+			cmdPrevious, false, auth.LevelControl},
+		"SEEK": commandDescriptor{
+			[]param{param{"position", paramTypeDuration, false, false}},
+			"Seek to the position (seconds or m:ss), or by offset if prefixed with + or -.",
+			cmdSeek, false, auth.LevelControl},
+		"STATUS": commandDescriptor{
+			[]param{},
+			"Print player state and the current track.",
+			cmdStatus, false, auth.LevelRead},
+		"IDLE": commandDescriptor{
+			[]param{param{"subsystem", paramTypeString, true, true}},
+			"Wait till any of the subsystems is changed. Can be canceled with NOIDLE.",
+			cmdIdle, true, auth.LevelRead},
+		"NOIDLE": commandDescriptor{
+			[]param{},
+			"Cancel running IDLE command.",
+			cmdPing, false, auth.LevelRead},
+		"FORMAT": commandDescriptor{
+			[]param{param{"format", paramTypeString, false, false}},
+			"Set responses format: text or json.",
+			cmdFormat, false, auth.LevelNone},
+		"PASSWORD": commandDescriptor{
+			[]param{param{"password", paramTypeString, false, false}},
+			"Authenticate and get permissions of the password.",
+			cmdPassword, false, auth.LevelNone},
+		"COMMANDS": commandDescriptor{
+			[]param{},
+			"List commands available for the client.",
+			cmdCommands, false, auth.LevelNone},
+		"HELP": commandDescriptor{
+			[]param{param{"command", paramTypeString, false, false}},
+			"Describe command and its parameters.",
+			cmdHelp, false, auth.LevelNone},
+		"CLIENTS": commandDescriptor{
+			[]param{},
+			"List connected clients.",
+			cmdClients, false, auth.LevelAdmin},
+		"KICK": commandDescriptor{
+			[]param{param{"id", paramTypeInt, false, false}},
+			"Disconnect the client.",
+			cmdKick, false, auth.LevelAdmin},
+		"LOGLEVEL": commandDescriptor{
+			[]param{param{"level", paramTypeString, true, false}, param{"subsystem", paramTypeString, true, false}},
+			"Print log level or set it (error, warning, info or debug) for all subsystems or the given one.",
+			cmdLogLevel, false, auth.LevelAdmin},
+		"CONFIG": commandDescriptor{
+			[]param{param{"action", paramTypeString, false, false}, param{"key", paramTypeString, true, false},
+				param{"value", paramTypeString, true, false}},
+			"Print configuration items (LIST), the item (GET key) or change it (SET key value).",
+			cmdConfig, false, auth.LevelAdmin},
+		"KILL": commandDescriptor{
+			[]param{},
+			"Stop player and terminate daemon.",
+			cmdKill, false, auth.LevelAdmin},
+	}
+}
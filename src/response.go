@@ -0,0 +1,263 @@
+// Structured command responses and their text and JSON representations.
+package protocol
+
+import (
+	"os"
+	"fmt"
+	"bytes"
+	"bufio"
+	"json"
+	"strconv"
+)
+
+// Response formats.
+const (
+	// Key: Value pairs, records are separated with empty lines.
+	formatText = iota
+	// One JSON document per response.
+	formatJSON
+)
+
+// Error codes.
+const (
+	// Command execution failed.
+	errorCodeFailed = iota + 1
+	// Request can't be parsed.
+	errorCodeSyntax
+	// Command is not supported.
+	errorCodeCommand
+	// Wrong command arguments.
+	errorCodeArgument
+	// Wrong command list usage.
+	errorCodeList
+	// Permission denied or authentication failed.
+	errorCodePermission
+)
+
+// protocolError is the error returned to the client.
+type protocolError struct {
+	// Error code.
+	code int
+	// Error description.
+	message string
+	// Name of the failed command in the commands list.
+	// Empty if command wasn't executed as a part of list.
+	command string
+	// Position of the failed command in the commands list.
+	index int
+}
+
+// newError returns new protocol error with the given code.
+func newError(code int, format string, args ...interface{}) os.Error {
+	return &protocolError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// toProtocolError converts any error to the protocol error.
+// Errors returned by other packages are treated as errorCodeFailed errors.
+func toProtocolError(err os.Error) *protocolError {
+	if perr, ok := err.(*protocolError); ok {
+		return perr
+	}
+
+	return &protocolError{code: errorCodeFailed, message: err.String()}
+}
+
+// String returns error description.
+func (err *protocolError) String() string {
+	if err.command != "" {
+		return fmt.Sprintf("[%d:%s] %s", err.index, err.command, err.message)
+	}
+
+	return err.message
+}
+
+// duration is the time period in seconds. It is printed as m:ss in text
+// format and as number of seconds in JSON.
+type duration int
+
+// String returns m:ss representation of the duration.
+func (d duration) String() string {
+	return fmt.Sprintf("%d:%02d", int(d)/60, int(d)%60)
+}
+
+// tagNumber is the track number from the tag. It is printed as is in text
+// format and as number (or null if it is not a number) in JSON.
+type tagNumber string
+
+// MarshalJSON implements json.Marshaler interface.
+func (n tagNumber) MarshalJSON() ([]byte, os.Error) {
+	i, err := strconv.Atoi(string(n))
+	if err != nil {
+		return []byte("null"), nil
+	}
+
+	return []byte(strconv.Itoa(i)), nil
+}
+
+// field is the one Key: Value pair of the record.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// record is the group of fields describing one object (track, playlist, ...)
+type record struct {
+	fields []field
+	// Bare record is printed in text format as values without keys.
+	bare bool
+}
+
+// Set appends new field to the record. Value can be a string, a number, a boolean
+// or a list of strings.
+func (rec *record) Set(key string, value interface{}) {
+	rec.fields = append(rec.fields, field{key, value})
+}
+
+// response collects records written by the command handler.
+type response struct {
+	records []*record
+}
+
+// newResponse returns new empty response.
+func newResponse() *response {
+	resp := new(response)
+	resp.records = make([]*record, 0)
+
+	return resp
+}
+
+// NewRecord appends new empty record to the response and returns it.
+func (resp *response) NewRecord() *record {
+	rec := new(record)
+	rec.fields = make([]field, 0)
+	resp.records = append(resp.records, rec)
+
+	return rec
+}
+
+// Append appends all records from the other response.
+func (resp *response) Append(other *response) {
+	resp.records = append(resp.records, other.records...)
+}
+
+// writeTextRecords writes records in the text format.
+func writeTextRecords(writer *bufio.Writer, records []*record) {
+	for i, rec := range records {
+		if i > 0 {
+			writer.WriteString("\n")
+		}
+
+		for _, f := range rec.fields {
+			if rec.bare {
+				writer.WriteString(fmt.Sprintf("%v\n", f.value))
+			} else if values, ok := f.value.([]string); ok {
+				for _, v := range values {
+					writer.WriteString(fmt.Sprintf("%s: %s\n", f.key, v))
+				}
+			} else {
+				writer.WriteString(fmt.Sprintf("%s: %v\n", f.key, f.value))
+			}
+		}
+	}
+}
+
+// writeTextError writes failed command completion line.
+func writeTextError(writer *bufio.Writer, err os.Error) {
+	writer.WriteString(fmt.Sprintf("ERROR %s\n", err))
+}
+
+// writeJSONRecords writes records as JSON array of objects.
+// Fields order is preserved.
+func writeJSONRecords(buf *bytes.Buffer, records []*record) {
+	buf.WriteString("[")
+	for i, rec := range records {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeJSONRecord(buf, rec)
+	}
+	buf.WriteString("]")
+}
+
+// writeJSONRecord writes record as JSON object. Fields order is preserved.
+func writeJSONRecord(buf *bytes.Buffer, rec *record) {
+	buf.WriteString("{")
+	for i, f := range rec.fields {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeJSONValue(buf, f.key)
+		buf.WriteString(":")
+		writeJSONValue(buf, f.value)
+	}
+	buf.WriteString("}")
+}
+
+// writeJSONValue writes JSON representation of the value.
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data = []byte("null")
+	}
+	buf.Write(data)
+}
+
+// writeJSONError writes JSON representation of the error object.
+func writeJSONError(buf *bytes.Buffer, err os.Error) {
+	perr := toProtocolError(err)
+
+	buf.WriteString(`"error":{"code":`)
+	writeJSONValue(buf, perr.code)
+	buf.WriteString(`,"message":`)
+	writeJSONValue(buf, perr.message)
+	if perr.command != "" {
+		buf.WriteString(`,"command":`)
+		writeJSONValue(buf, perr.command)
+		buf.WriteString(`,"index":`)
+		writeJSONValue(buf, perr.index)
+	}
+	buf.WriteString("}")
+}
+
+// writeJSON writes response document:
+// {"ok": true, "data": [{...}, ...]} or {"ok": false, "error": {"code": 1, "message": "..."}}
+func writeJSON(writer *bufio.Writer, resp *response, err os.Error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(`{"ok":`)
+	writeJSONValue(buf, err == nil)
+	if resp != nil {
+		buf.WriteString(`,"data":`)
+		writeJSONRecords(buf, resp.records)
+	}
+	if err != nil {
+		buf.WriteString(",")
+		writeJSONError(buf, err)
+	}
+	buf.WriteString("}\n")
+
+	writer.Write(buf.Bytes())
+}
+
+// writeJSONList writes response document for the BEGIN_OK commands list,
+// where every command has its own data:
+// {"ok": true, "results": [[{...}, ...], ...]}
+func writeJSONList(writer *bufio.Writer, resps []*response, err os.Error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(`{"ok":`)
+	writeJSONValue(buf, err == nil)
+	buf.WriteString(`,"results":[`)
+	for i, resp := range resps {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeJSONRecords(buf, resp.records)
+	}
+	buf.WriteString("]")
+	if err != nil {
+		buf.WriteString(",")
+		writeJSONError(buf, err)
+	}
+	buf.WriteString("}\n")
+
+	writer.Write(buf.Bytes())
+}
@@ -0,0 +1,269 @@
+// HTTP/REST gateway to the protocol commands.
+package protocol
+
+import (
+	"os"
+	"bufio"
+	"strings"
+	"http"
+	"./vfs"
+	"./auth"
+	"./config"
+)
+
+// route maps HTTP request to the protocol command.
+type route struct {
+	// HTTP method.
+	method string
+	// Path pattern. Segments started with colon are parameters,
+	// e. g. /playlists/:name/tracks
+	pattern string
+	// Name of the command to be executed.
+	command string
+	// Names of the command parameters. Values are taken from path parameters
+	// or from request form values.
+	params []string
+}
+
+// All supported routes. Commands listed in the http.commands item can be
+// executed with the POST /commands/NAME?arg=...&arg=... request also.
+var routes = []route{
+	route{"GET", "/ls", "LS", []string{"path"}},
+	route{"GET", "/status", "STATUS", []string{}},
+	route{"POST", "/player/play", "PLAY", []string{"playlist", "position"}},
+	route{"POST", "/player/playvfs", "PLAYVFS", []string{"track"}},
+	route{"POST", "/player/pause", "PAUSE", []string{}},
+	route{"POST", "/player/stop", "STOP", []string{}},
+	route{"POST", "/player/next", "NEXT", []string{}},
+	route{"POST", "/player/previous", "PREVIOUS", []string{}},
+	route{"POST", "/player/seek", "SEEK", []string{"position"}},
+	route{"GET", "/playlists", "PLAYLISTS", []string{}},
+	route{"POST", "/playlists", "ADDPLAYLIST", []string{"name"}},
+	route{"GET", "/playlists/:name", "PLAYLIST", []string{"name"}},
+	route{"DELETE", "/playlists/:name", "DELETEPLAYLIST", []string{"name"}},
+	route{"POST", "/playlists/:name/tracks", "ADD", []string{"name", "track"}},
+	route{"DELETE", "/playlists/:name/tracks/:position", "REMOVE", []string{"name", "position"}},
+	route{"POST", "/playlists/:name/move", "MOVE", []string{"name", "from", "to"}},
+	route{"GET", "/commands", "COMMANDS", []string{}},
+	route{"GET", "/help/:command", "HELP", []string{"command"}},
+}
+
+// Prefix of the generic command execution path.
+const commandsPath = "/commands/"
+
+// Header the password is passed in. Passwords aren't accepted in the URL
+// or the form, so they don't get to logs and browser history.
+const passwordHeader = "X-Chubd-Password"
+
+// Content types browser can send cross-origin without preflight request.
+// Requests which change state are rejected if they have one of them.
+var formContentTypes = []string{
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+	"text/plain",
+}
+
+// match returns path parameters if request matches the route.
+func (r *route) match(method string, path string) (params map[string]string, ok bool) {
+	if r.method != method {
+		return nil, false
+	}
+
+	patternSegments := strings.Split(strings.Trim(r.pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params = make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// RESTHandler implements http.Handler interface and executes protocol commands
+// for HTTP requests. Responses are always in JSON format.
+// Gateway is stateless: working directory is passed with every request as the cwd
+// parameter and password in the X-Chubd-Password header. Requests other than GET
+// change state, so they require the password and are rejected if they are
+// cross-origin or form-encoded (i. e. they could be forged by another site).
+type RESTHandler struct {
+}
+
+// NewRESTHandler returns newly initialized HTTP requests handler.
+func NewRESTHandler() *RESTHandler {
+	return new(RESTHandler)
+}
+
+// ServeHTTP handles HTTP request.
+func (h *RESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := checkOrigin(r)
+	var cmd *command
+	if err == nil {
+		cmd, err = h.command(r)
+	}
+	if err == nil && isBlocking(cmd) {
+		err = newError(errorCodeCommand, "Command '%s' is not available over HTTP", cmd.Name)
+	}
+
+	ch := newSessionHandler()
+	resp := newResponse()
+	if err == nil {
+		cwd := r.FormValue("cwd")
+		if cwd != "" {
+			err = ch.fs.SetWorkingDir(cwd)
+		}
+	}
+	if err == nil {
+		err = authenticate(ch, r)
+	}
+	if err == nil {
+		err = ch.execute(resp, cmd)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err != nil {
+		resp = nil
+		w.WriteHeader(httpStatus(err))
+	}
+
+	writer := bufio.NewWriter(w)
+	writeJSON(writer, resp, err)
+	writer.Flush()
+}
+
+// command builds command from the request.
+func (h *RESTHandler) command(r *http.Request) (cmd *command, err os.Error) {
+	r.ParseForm()
+
+	if r.Method == "POST" && strings.HasPrefix(r.URL.Path, commandsPath) {
+		cmd = new(command)
+		cmd.Name = strings.ToUpper(r.URL.Path[len(commandsPath):])
+		if !isAllowed(cmd.Name) {
+			return nil, newError(errorCodeCommand, "Command '%s' is not available over HTTP", cmd.Name)
+		}
+		cmd.Parameters = r.Form["arg"]
+		if cmd.Parameters == nil {
+			cmd.Parameters = make([]string, 0)
+		}
+
+		return cmd, nil
+	}
+
+	for _, rt := range routes {
+		pathParams, ok := rt.match(r.Method, r.URL.Path)
+		if !ok {
+			continue
+		}
+
+		cmd = new(command)
+		cmd.Name = rt.command
+		cmd.Parameters = make([]string, 0, len(rt.params))
+		for _, name := range rt.params {
+			value, ok := pathParams[name]
+			if !ok {
+				value = r.FormValue(name)
+			}
+			cmd.Parameters = append(cmd.Parameters, value)
+		}
+
+		// Omitted trailing parameters are optional ones.
+		for len(cmd.Parameters) > 0 && cmd.Parameters[len(cmd.Parameters)-1] == "" {
+			cmd.Parameters = cmd.Parameters[:len(cmd.Parameters)-1]
+		}
+
+		return cmd, nil
+	}
+
+	return nil, newError(errorCodeCommand, "No route for %s %s", r.Method, r.URL.Path)
+}
+
+// isAllowed returns true if command is listed in the http.commands item.
+func isAllowed(name string) bool {
+	allowed, _ := config.Configurations.GetList("http.commands")
+	for _, a := range allowed {
+		if strings.ToUpper(a) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changesState returns true if request can change the daemon state.
+func changesState(r *http.Request) bool {
+	return r.Method != "GET" && r.Method != "HEAD"
+}
+
+// checkOrigin rejects requests which change state if they are sent from
+// another origin or with the form content type.
+func checkOrigin(r *http.Request) os.Error {
+	if !changesState(r) {
+		return nil
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		url, err := http.ParseURL(origin)
+		if err != nil || url.Host != r.Host {
+			return newError(errorCodePermission, "Cross-origin request from '%s' is rejected", origin)
+		}
+	}
+
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	for _, t := range formContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return newError(errorCodePermission, "Form-encoded %s request is rejected", r.Method)
+		}
+	}
+
+	return nil
+}
+
+// authenticate sets permission level of the handler by the password from
+// the request header. Requests which change state require the password.
+func authenticate(ch *CommandHandler, r *http.Request) os.Error {
+	password := r.Header.Get(passwordHeader)
+	if password == "" {
+		if changesState(r) {
+			return newError(errorCodePermission, "%s request requires the %s header", r.Method, passwordHeader)
+		}
+		return nil
+	}
+
+	level, err := auth.Authenticate(password, r.RemoteAddr)
+	if err != nil {
+		log.Warning("HTTP authentication failed. %s", err)
+		return newError(errorCodePermission, "%s", err)
+	}
+	ch.level = level
+
+	return nil
+}
+
+// newSessionHandler returns command handler for one stateless request.
+func newSessionHandler() *CommandHandler {
+	ch := new(CommandHandler)
+	ch.fs = vfs.New()
+	ch.format = formatJSON
+	ch.level = auth.DefaultLevel()
+
+	return ch
+}
+
+// httpStatus returns HTTP status code for the error.
+func httpStatus(err os.Error) int {
+	switch toProtocolError(err).code {
+	case errorCodeCommand:
+		return http.StatusNotFound
+	case errorCodePermission:
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadRequest
+}
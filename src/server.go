@@ -4,12 +4,17 @@ package server
 import (
 	"os"
 	"net"
-	"net/textproto"
 	"bufio"
 	"fmt"
 	"sync"
+	"time"
+	"strings"
+	"./logger"
 )
 
+// Log of the server subsystem.
+var log = logger.New("server")
+
 // Server is the server itself interface, which can listen network (TCP, UNIX Sockets, etc.)
 // connections and process client's commands with the CommandHandler interface implementations.
 type Server interface {
@@ -19,6 +24,10 @@ type Server interface {
 	// Serve start main server loop, which accepts connection form the clients
 	// and do next communication processing.
 	Serve() os.Error
+	// Shutdown stops accepting new connections, lets connected clients know that
+	// server is going down and closes their connections after running commands
+	// are finished.
+	Shutdown()
 }
 
 // HandleConnection method is called every time client is connected. And this method should returns
@@ -37,43 +46,186 @@ type CommandHandler interface {
 	HandleCommand(writer *bufio.Writer, command string) bool
 }
 
-// tcpServer represents server which works on TCP/IP netwoks.
-type tcpServer struct {
-	// Number of currently connected clients.
-	clientsCount int
-	// Mutex for protecting clientsCount field.
-	mutex             sync.Mutex
-	listener          *net.TCPListener
+// Interruptible is implemented by CommandHandler which has long running (blocking) commands.
+// While such command is running server keeps reading the client's input and passes every
+// recieved line to the Interrupt method.
+type Interruptible interface {
+	// Interrupt is called for every line recieved while HandleCommand is running
+	// the command. true return result means that line was consumed by the handler.
+	// Not consumed lines are passed to HandleCommand after the running command is finished.
+	Interrupt(command string, line string) bool
+}
+
+// EventSource is implemented by CommandHandler which produces unsolicited messages
+// (e. g. player events). Servers which can push data to the client (like WebSocket server)
+// send every recieved message to the client.
+type EventSource interface {
+	// Events returns channel of the messages. Channel is closed after quit channel is closed.
+	Events(quit <-chan bool) <-chan string
+}
+
+// Greeter is implemented by CommandHandler which speaks its own protocol
+// and has to send its own greeting message instead of the default one.
+type Greeter interface {
+	// Greeting returns message which is sent to the client right after connection.
+	Greeting() string
+}
+
+// Rejecter is implemented by CommandHandler which speaks its own protocol
+// and has to send its own error message when the client is rejected.
+type Rejecter interface {
+	// Rejection returns message which is sent to the rejected client.
+	Rejection(reason string) string
+}
+
+// ShutdownNotifier is implemented by CommandHandler which has to let the client
+// know that server is going down.
+type ShutdownNotifier interface {
+	// NotifyShutdown is called after the last command is finished and before
+	// connection is closed. Notification should be written to the writer.
+	NotifyShutdown(writer *bufio.Writer)
+}
+
+// Closer is implemented by CommandHandler which has to release resources when
+// client connection is closed.
+type Closer interface {
+	// Close is called once when connection was closed. It can be called while
+	// HandleCommand is running, so it should cancel any blocking command.
+	Close()
+}
+
+// sessions tracks connected clients of the server, so they can be closed on shutdown.
+type sessions struct {
+	// true if server is shutting down and new clients are rejected.
+	closing bool
+	// Channel is closed when server is shutting down.
+	shutdown chan bool
+	// Running sessions.
+	running sync.WaitGroup
+	// Mutex for protecting closing field.
+	mutex sync.Mutex
+}
+
+// newSessions returns newly initialized sessions tracker.
+func newSessions() *sessions {
+	s := new(sessions)
+	s.shutdown = make(chan bool)
+
+	return s
+}
+
+// add registers new client. false is returned if server is shutting down.
+func (s *sessions) add() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.running.Add(1)
+
+	return true
+}
+
+// remove unregisters client.
+func (s *sessions) remove() {
+	s.running.Done()
+}
+
+// isClosing returns true if server is shutting down.
+func (s *sessions) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closing
+}
+
+// stop asks all sessions to finish.
+func (s *sessions) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.closing {
+		s.closing = true
+		close(s.shutdown)
+	}
+}
+
+// wait waits till all sessions are finished (but not longer than shutdownTimeout).
+func (s *sessions) wait() {
+	done := make(chan bool)
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+	}
+}
+
+// streamServer represents server which accepts stream connections
+// from any listener (TCP/IP, UNIX sockets).
+type streamServer struct {
+	// Connected clients.
+	sessions          *sessions
+	listener          net.Listener
 	connectionHandler ConnectionHandler
 	commandHandler    CommandHandler
 }
 
-// NewTCPServer creates newly initialized TCP server.
-func NewTCPServer(ip string, port int) (srv Server, err os.Error) {
-	ipAddr := net.ParseIP(ip)
-	if ipAddr == nil {
-		msg := fmt.Sprintf("'%s' is not correct IP address.", ip)
-		return nil, os.NewError(msg)
+// Prefix of the UNIX socket addresses in the bind list.
+const unixAddressPrefix = "unix:"
+
+// Listen creates server for the bind address: host:port, [ipv6]:port,
+// tls:host:port or unix:/path/to/socket. unixMode is the socket file permissions for UNIX socket
+// addresses. Returned error names the failed address.
+func Listen(address string, unixMode uint32) (srv Server, err os.Error) {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		srv, err = NewUnixServer(address[len(unixAddressPrefix):], unixMode)
+	} else if strings.HasPrefix(address, tlsAddressPrefix) {
+		srv, err = NewTLSServer(address[len(tlsAddressPrefix):])
+	} else {
+		srv, err = NewTCPServer(address)
+	}
+	if err != nil {
+		return nil, os.NewError(fmt.Sprintf("Can't listen '%s'. %s", address, err))
 	}
-	addr := net.TCPAddr{ipAddr, port}
-	listener, err := net.ListenTCP("tcp", &addr)
+
+	return srv, nil
+}
+
+// NewTCPServer creates newly initialized TCP server for the host:port address.
+// Host can be IPv4 or IPv6 (in square brackets) address or hostname, which
+// is resolved. Server listens all interfaces (both IPv4 and IPv6 if system
+// supports dual-stack sockets) if host is empty.
+func NewTCPServer(address string) (srv Server, err os.Error) {
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, err
 	}
 
-	server := new(tcpServer)
+	return newStreamServer(listener), nil
+}
+
+// newStreamServer creates newly initialized server for the given listener.
+func newStreamServer(listener net.Listener) *streamServer {
+	server := new(streamServer)
 	server.listener = listener
+	server.sessions = newSessions()
 
-	return server, nil
+	return server
 }
 
 // Set connection handler.
-func (srv *tcpServer) SetConnectionHandler(handler ConnectionHandler) {
+func (srv *streamServer) SetConnectionHandler(handler ConnectionHandler) {
 	srv.connectionHandler = handler
 }
 
-// Serve starts TCP server main loop and make it ready to clients handling.
-func (srv *tcpServer) Serve() os.Error {
+// Serve starts server main loop and make it ready to clients handling.
+func (srv *streamServer) Serve() os.Error {
 	if srv.connectionHandler == nil {
 		return os.NewError("SetConnectionHandler should be call first")
 	}
@@ -81,64 +233,261 @@ func (srv *tcpServer) Serve() os.Error {
 	for {
 		conn, err := srv.listener.Accept()
 		if err != nil {
+			if srv.sessions.isClosing() {
+				return nil // Listener was closed by Shutdown.
+			}
 			return err
 		}
 
+		if !srv.sessions.add() {
+			conn.Close()
+			continue
+		}
+
 		commandHandler := srv.connectionHandler.HandleConnection(conn)
-		if commandHandler != nil {
-			go srv.handleClient(conn, commandHandler)
-		} else {
+		if commandHandler == nil {
+			srv.sessions.remove()
 			conn.Close()
+			continue
 		}
+
+		c := addClient(conn, commandHandler)
+		if c == nil {
+			go func(conn net.Conn) {
+				defer srv.sessions.remove()
+				defer conn.Close()
+				conn.SetTimeout(rejectTimeout)
+				conn.Write([]byte(rejectTooManyClients(conn, commandHandler)))
+			}(conn)
+			continue
+		}
+
+		go srv.handleClient(c)
 	}
 
 	return nil
 }
 
+// Shutdown implements Server interface.
+func (srv *streamServer) Shutdown() {
+	srv.sessions.stop()
+	srv.listener.Close()
+	srv.sessions.wait()
+}
+
 // helloMessage returns server greeting string.
-func (srv *tcpServer) helloMessage() string {
+func (srv *streamServer) helloMessage() string {
 	return "Chubd 0.0 service\nOK\n" // TODO: Retrive version number from some global spot.
 }
 
 // handleClient handle the client and organize communication between the client
 // and CommandHandler.
-func (srv *tcpServer) handleClient(conn net.Conn, commandHandler CommandHandler) {
-	srv.addClient()
-	defer srv.removeClient()
+func (srv *streamServer) handleClient(c *client) {
+	conn := c.conn
+	commandHandler := c.info.Handler
+	defer srv.sessions.remove()
+	defer conn.Close()
+	defer removeClient(c)
+
+	if !handshake(conn, commandHandler) {
+		closeHandler(commandHandler)
+		return
+	}
 
-	// Say hello to client.
-	writer := bufio.NewWriter(conn)
-	writer.WriteString(srv.helloMessage())
+	// Say hello to client. Output is switched to the TLS connection after upgrade.
+	output := &switchWriter{conn}
+	writer := bufio.NewWriter(output)
+	if greeter, ok := commandHandler.(Greeter); ok {
+		writer.WriteString(greeter.Greeting())
+	} else {
+		writer.WriteString(srv.helloMessage())
+	}
 	writer.Flush()
 
+	// Client's input is read in separate goroutine, so we can recieve lines
+	// while the command is still running.
+	lines := make(chan string)
+	quit := make(chan bool)
+	defer close(quit)
+
+	upgrader, _ := commandHandler.(Upgrader)
+	upgrades := make(chan net.Conn, 1)
+	go readLines(conn, lines, quit, upgrader, upgrades)
+
+	var afterCommand func(command string)
+	if upgrader != nil {
+		afterCommand = func(command string) {
+			if upgrader.IsUpgrade(command) {
+				upgrade(conn, output, upgrader, upgrades)
+			}
+		}
+	}
+
+	serveSession(c, lines, writer, srv.sessions.shutdown, afterCommand)
+}
+
+// serveSession passes client's commands recieved from lines channel to the CommandHandler
+// till the client ends conversation, lines channel is closed, client is idle for too long
+// or shutdown channel is closed. On shutdown running command is finished (blocking
+// commands are canceled) before return. afterCommand (if not nil) is called after
+// every handled command.
+func serveSession(c *client, lines <-chan string, writer *bufio.Writer, shutdown <-chan bool, afterCommand func(command string)) {
+	commandHandler := c.info.Handler
+
+	// true if connection was closed by client.
+	closed := false
+	closer, _ := commandHandler.(Closer)
+	closeHandler := func() {
+		if closer != nil {
+			closer.Close()
+			closer = nil
+		}
+	}
+	defer closeHandler()
+
+	interruptible, _ := commandHandler.(Interruptible)
+	// Lines recieved while command was running and not consumed by the handler.
+	queue := make([]string, 0)
+
 	for {
-		reader := textproto.NewReader(bufio.NewReader(conn))
-		command, err := reader.ReadLine() // TODO: Parse request string to command.
-		if err != nil {
-			return // Connection was closed by client, or something like that.
+		var command string
+		select {
+		case <-shutdown:
+			notifyShutdown(commandHandler, writer)
+			return
+		default:
+		}
+
+		if len(queue) > 0 {
+			command = queue[0]
+			queue = queue[1:]
+		} else {
+			var timeout <-chan int64
+			if idleTimeout > 0 {
+				timeout = time.After(idleTimeout)
+			}
+
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					return // Connection was closed by client, or something like that.
+				}
+				command = line
+			case <-shutdown:
+				notifyShutdown(commandHandler, writer)
+				return
+			case <-timeout:
+				return // Client is idle for too long.
+			}
+		}
+		c.setLastCommand(command)
+
+		done := make(chan bool)
+		go func() {
+			done <- commandHandler.HandleCommand(writer, command)
+		}()
+
+		exit := false
+		running := true
+		for running {
+			select {
+			case exit = <-done:
+				running = false
+			case line, ok := <-lines:
+				if !ok {
+					// Nobody will read the response, so cancel running command.
+					closed = true
+					lines = nil
+					closeHandler()
+				} else if interruptible == nil || !interruptible.Interrupt(command, line) {
+					queue = append(queue, line)
+				}
+			case <-shutdown:
+				// Let running command finish, but cancel blocking one.
+				shutdown = nil
+				queue = queue[:0]
+				closeHandler()
+			}
+		}
+
+		if afterCommand != nil && !closed {
+			afterCommand(command)
 		}
 
-		exit := commandHandler.HandleCommand(bufio.NewWriter(conn), command)
-		if exit {
+		if exit || closed {
 			break // Client wants to end this conversation.
 		}
+		if shutdown == nil {
+			notifyShutdown(commandHandler, writer)
+			break
+		}
+	}
+}
+
+// Time in nanoseconds the rejection message is tried to be sent for.
+const rejectTimeout = 5e9
+
+// rejectTooManyClients logs rejected connection, releases its handler and
+// returns message for the client.
+func rejectTooManyClients(conn net.Conn, commandHandler CommandHandler) string {
+	log.Warning("Client from %s is rejected: too many clients", conn.RemoteAddr())
+	closeHandler(commandHandler)
+
+	if rejecter, ok := commandHandler.(Rejecter); ok {
+		return rejecter.Rejection("Too many clients")
 	}
 
-	conn.Close()
+	return "ERROR Too many clients\n"
 }
 
-// addClient register new client.
-func (srv *tcpServer) addClient() {
-	srv.mutex.Lock()
-	defer srv.mutex.Unlock()
+// closeHandler releases handler resources of the client which wasn't served.
+func closeHandler(commandHandler CommandHandler) {
+	if closer, ok := commandHandler.(Closer); ok {
+		closer.Close()
+	}
+}
 
-	srv.clientsCount++
+// notifyShutdown lets the client know that server is going down.
+func notifyShutdown(commandHandler CommandHandler, writer *bufio.Writer) {
+	if notifier, ok := commandHandler.(ShutdownNotifier); ok {
+		notifier.NotifyShutdown(writer)
+		writer.Flush()
+	}
 }
 
-// removeClient delete new client.
-func (srv *tcpServer) removeClient() {
-	srv.mutex.Lock()
-	defer srv.mutex.Unlock()
+// readLines reads client's input line by line and sends lines to the channel.
+// Channel is closed when connection is closed or client sends line longer than
+// maxLineLength. After the upgrade request is sent reading is paused till the upgraded
+// connection (or nil if connection wasn't upgraded) is recieved from upgrades channel.
+func readLines(conn net.Conn, lines chan<- string, quit <-chan bool, upgrader Upgrader, upgrades <-chan net.Conn) {
+	defer close(lines)
+
+	reader, err := bufio.NewReaderSize(conn, maxLineLength)
+	if err != nil {
+		log.Error("Can't read from %s. %s", conn.RemoteAddr(), err)
+		return
+	}
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil || isPrefix {
+			return
+		}
+
+		select {
+		case lines <- string(line):
+		case <-quit:
+			return
+		}
 
-	srv.clientsCount--
+		if upgrader != nil && upgrader.IsUpgrade(string(line)) {
+			select {
+			case newConn := <-upgrades:
+				if newConn != nil {
+					reader, _ = bufio.NewReaderSize(newConn, maxLineLength)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}
 }
@@ -0,0 +1,123 @@
+// Session package implements the state shared by the protocol handlers
+// (chubd and MPD ones): subscription for the idle commands and command lists.
+package session
+
+import (
+	"os"
+	"./event"
+	"./player"
+)
+
+// Session is the state of one client connection.
+type Session struct {
+	// Collects subsystems changes for the idle command.
+	Listener *event.Listener
+	// Idle command waiting is canceled when value is sent to this channel.
+	NoIdle chan bool
+	// true if command list is being recieved.
+	inList bool
+	// true if every command of the list should be followed with the list OK line.
+	listOk bool
+	// Recieved commands of the list.
+	list []interface{}
+}
+
+// New returns newly initialized session.
+func New() *Session {
+	s := new(Session)
+	s.Listener = event.Subscribe()
+	s.NoIdle = make(chan bool, 1)
+
+	return s
+}
+
+// Close implements server.Closer interface.
+func (s *Session) Close() {
+	s.CancelIdle()
+	s.Listener.Unsubscribe()
+}
+
+// CancelIdle cancels running (or about to be run) idle command.
+func (s *Session) CancelIdle() {
+	select {
+	case s.NoIdle <- true:
+	default:
+	}
+}
+
+// DropCancelIdle drops idle cancellation which was recieved when idle
+// command was already finished, so the next idle command isn't canceled.
+func (s *Session) DropCancelIdle() {
+	select {
+	case <-s.NoIdle:
+	default:
+	}
+}
+
+// BeginList starts recieving command list. If ok is true every command
+// response should be followed with the list OK line.
+func (s *Session) BeginList(ok bool) {
+	s.inList = true
+	s.listOk = ok
+	s.list = make([]interface{}, 0)
+}
+
+// InList returns true if command list is being recieved.
+func (s *Session) InList() bool {
+	return s.inList
+}
+
+// ListOk returns true if every command response of the list should be
+// followed with the list OK line.
+func (s *Session) ListOk() bool {
+	return s.listOk
+}
+
+// AddToList appends parsed command to the list being recieved.
+func (s *Session) AddToList(cmd interface{}) {
+	s.list = append(s.list, cmd)
+}
+
+// EndList stops recieving command list and returns its commands.
+func (s *Session) EndList() []interface{} {
+	list := s.list
+	s.AbortList()
+
+	return list
+}
+
+// AbortList drops command list being recieved (e. g. when broken
+// command was recieved).
+func (s *Session) AbortList() {
+	s.inList = false
+	s.list = nil
+}
+
+// Execute runs the command. Non-blocking commands are executed under
+// the player lock, so no other client can interfere.
+func Execute(blocking bool, run func() os.Error) os.Error {
+	if blocking {
+		return run()
+	}
+
+	player.Lock()
+	defer player.Unlock()
+
+	return run()
+}
+
+// RunList runs the commands of the list under the player lock. Execution
+// is stopped at the first failed command and its error is returned.
+func RunList(list []interface{}, run func(i int, cmd interface{}) os.Error) os.Error {
+	player.Lock()
+	defer player.Unlock()
+
+	for i, cmd := range list {
+		err := run(i, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
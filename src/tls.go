@@ -0,0 +1,122 @@
+// TLS support: TLS listeners and STARTTLS-style connection upgrading.
+package server
+
+import (
+	"os"
+	"io"
+	"net"
+	"crypto/tls"
+)
+
+// Prefix of the TLS addresses in the bind list.
+const tlsAddressPrefix = "tls:"
+
+// Time in nanoseconds client has to complete TLS handshake in.
+const handshakeTimeout = 10e9
+
+// TLS configuration shared by all servers. nil if TLS isn't configured.
+var tlsConfig *tls.Config
+
+// SetTLSConfig sets TLS configuration (certificate, key, etc.) used by TLS
+// listeners and for connections upgrading.
+func SetTLSConfig(config *tls.Config) {
+	tlsConfig = config
+}
+
+// TLSConfig returns TLS configuration or nil if TLS isn't configured.
+func TLSConfig() *tls.Config {
+	return tlsConfig
+}
+
+// Upgrader is implemented by CommandHandler which supports upgrading of
+// the plain connection to TLS (like STARTTLS command). When upgrade request
+// is accepted server does TLS handshake right after the response is sent.
+type Upgrader interface {
+	// IsUpgrade returns true if line is the upgrade request. Input isn't read
+	// till the request is handled. It is called from the reading goroutine,
+	// so it shouldn't depend on the handler state.
+	IsUpgrade(line string) bool
+	// UpgradeAccepted is called after upgrade request was handled and returns
+	// true if handler accepted the request.
+	UpgradeAccepted() bool
+	// Upgraded is called when TLS handshake is done (both for upgraded
+	// connections and connections accepted by TLS listeners).
+	Upgraded(state tls.ConnectionState)
+}
+
+// NewTLSServer creates newly initialized TCP server for the host:port address,
+// which accepts TLS connections only.
+func NewTLSServer(address string) (srv Server, err os.Error) {
+	if tlsConfig == nil {
+		return nil, os.NewError("TLS isn't configured")
+	}
+
+	listener, err := tls.Listen("tcp", address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStreamServer(listener), nil
+}
+
+// switchWriter is the io.Writer, which output can be switched
+// to another writer (e. g. after TLS handshake).
+type switchWriter struct {
+	w io.Writer
+}
+
+// Write implements io.Writer interface.
+func (sw *switchWriter) Write(data []byte) (n int, err os.Error) {
+	return sw.w.Write(data)
+}
+
+// handshake does TLS handshake for the connection accepted by TLS listener
+// and lets handler know about it. false is returned if handshake failed or
+// wasn't completed in time. Connection is already counted against the clients
+// limit, so handshaking clients can't exceed it.
+func handshake(conn net.Conn, commandHandler CommandHandler) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return true // Plain connection.
+	}
+
+	conn.SetTimeout(handshakeTimeout)
+	err := tlsConn.Handshake()
+	if err != nil {
+		log.Warning("TLS handshake with %s failed. %s", conn.RemoteAddr(), err)
+		return false
+	}
+	conn.SetTimeout(0)
+
+	if upgrader, ok := commandHandler.(Upgrader); ok {
+		upgrader.Upgraded(tlsConn.ConnectionState())
+	}
+
+	return true
+}
+
+// upgrade upgrades plain connection to TLS if handler accepted the upgrade request.
+// New connection (or nil if connection wasn't upgraded) is sent to the upgrades
+// channel, so reading goroutine can continue. Connection is closed if handshake failed
+// or wasn't completed in time.
+func upgrade(conn net.Conn, output *switchWriter, upgrader Upgrader, upgrades chan<- net.Conn) {
+	if !upgrader.UpgradeAccepted() || tlsConfig == nil {
+		upgrades <- nil
+		return
+	}
+
+	tlsConn := tls.Server(conn, tlsConfig)
+	conn.SetTimeout(handshakeTimeout)
+	err := tlsConn.Handshake()
+	if err != nil {
+		log.Warning("STARTTLS handshake with %s failed. %s", conn.RemoteAddr(), err)
+		conn.Close()
+		upgrades <- nil
+		return
+	}
+	conn.SetTimeout(0)
+
+	output.w = tlsConn
+	upgrader.Upgraded(tlsConn.ConnectionState())
+	upgrades <- tlsConn
+}
@@ -0,0 +1,68 @@
+// UNIX domain socket server implementation.
+package server
+
+import (
+	"os"
+	"fmt"
+	"net"
+)
+
+// unixServer represents server which accepts connections on the UNIX domain socket.
+// Access to the server is controlled with the socket file permissions.
+type unixServer struct {
+	*streamServer
+	// Path to the socket file.
+	path string
+}
+
+// NewUnixServer creates newly initialized UNIX socket server. Socket file
+// is created with the given permissions. Stale socket file left by the
+// crashed daemon is removed, but socket used by a running process isn't touched.
+func NewUnixServer(path string, mode uint32) (srv Server, err os.Error) {
+	err = removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, mode)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	server := new(unixServer)
+	server.streamServer = newStreamServer(listener)
+	server.path = path
+
+	return server, nil
+}
+
+// Shutdown implements Server interface. Socket file is removed.
+func (srv *unixServer) Shutdown() {
+	srv.streamServer.Shutdown()
+	os.Remove(srv.path)
+}
+
+// removeStaleSocket removes socket file if nobody listens it.
+func removeStaleSocket(path string) os.Error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil // Nothing to remove.
+	}
+	if !fi.IsSocket() {
+		return os.NewError(fmt.Sprintf("'%s' exists and is not a socket", path))
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return os.NewError(fmt.Sprintf("'%s' is used by another process", path))
+	}
+
+	return os.Remove(path)
+}
@@ -1,8 +1,14 @@
 package utils
 
 import (
-	"strings"
+	"os"
+	"fmt"
+	"sort"
 	"path"
+	"bytes"
+	"strings"
+	"unicode"
+	"utf8"
 )
 
 // ExtensionMatch returns true is given file's extension matches with pattern.
@@ -12,3 +18,91 @@ func ExtensionMatch(filename string, extension string) bool {
 
 	return ext == extension
 }
+
+// Tokenize splits request string into tokens. Tokens are separated with
+// whitespace characters. Token can be quoted with double or single quotes
+// to keep whitespaces inside it. Backslash escapes next character, so it can
+// be used for writing quote characters or backslash itself.
+// Example: CD "Pink Floyd/1973 - The Dark Side of the Moon"
+func Tokenize(str string) (tokens []string, err os.Error) {
+	tokens = make([]string, 0)
+
+	// Current token.
+	token := new(bytes.Buffer)
+	// true if token was started. Empty quoted string is a valid token too.
+	inToken := false
+	// Currently opened quote character or 0 if we are out of quotes.
+	quote := 0
+	// true if previous character was backslash.
+	escaped := false
+
+	for i := 0; i < len(str); {
+		c, size := utf8.DecodeRuneInString(str[i:])
+		if c == utf8.RuneError && size == 1 {
+			return nil, os.NewError(fmt.Sprintf("Invalid UTF-8 sequence at position %d", i))
+		}
+		i += size
+
+		switch {
+		case escaped:
+			token.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			inToken = true
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				token.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			inToken = true
+			quote = c
+		case unicode.IsSpace(c):
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			inToken = true
+			token.WriteRune(c)
+		}
+	}
+
+	if escaped {
+		return nil, os.NewError("Unexpected end of line after escape character")
+	}
+	if quote != 0 {
+		return nil, os.NewError(fmt.Sprintf("Unterminated quoted string, %c expected", quote))
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// StringArray is helper type for sorting strings.
+type StringArray []string
+
+// Len returns length of the array.
+func (sa StringArray) Len() int {
+	return len(sa)
+}
+
+// Less returns true if i-element of the array less than j-element.
+func (sa StringArray) Less(i int, j int) bool {
+	return sa[i] < sa[j]
+}
+
+// Swap swaps two array elements.
+func (sa StringArray) Swap(i int, j int) {
+	sa[i], sa[j] = sa[j], sa[i]
+}
+
+// Sort sorts array in ascending order.
+func (sa StringArray) Sort() {
+	sort.Sort(sa)
+}
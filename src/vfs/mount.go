@@ -0,0 +1,217 @@
+// Mount table: several physical directories mounted into one VFS tree.
+package vfs
+
+import (
+	"os"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"strings"
+	"./config"
+	"./event"
+)
+
+// Mount is the physical directory mounted under the top-level VFS directory.
+// Mounts are defined with the fs.mounts item: list of name=path pairs,
+// e. g. "local=/srv/music, nas=/mnt/nas/music". If there are no mounts
+// fs.root is the root of the VFS.
+type Mount struct {
+	// Name of the top-level directory.
+	Name string
+	// Physical path.
+	Root string
+	// true if mount is listed in the fs.readonly item. Files of the read-only
+	// mount can be opened by the VFS for reading only (see Path.Open).
+	ReadOnly bool
+	// true if mount is listed in the fs.hidden item. Hidden mount isn't listed
+	// in the VFS root, but can be accessed by its path.
+	Hidden bool
+}
+
+// MountArray is helper type for sorting mounts by name.
+type MountArray []*Mount
+
+// Len returns length of the array.
+func (ma MountArray) Len() int {
+	return len(ma)
+}
+
+// Less returns true if i-element of the array less than j-element.
+func (ma MountArray) Less(i int, j int) bool {
+	return ma[i].Name < ma[j].Name
+}
+
+// Swap swaps two array elements.
+func (ma MountArray) Swap(i int, j int) {
+	ma[i], ma[j] = ma[j], ma[i]
+}
+
+// Sort sorts array in ascending order.
+func (ma MountArray) Sort() {
+	sort.Sort(ma)
+}
+
+// Current mount table sorted by name. It is rebuilt when configuration is changed.
+var mounts []*Mount
+// Mutex for protecting mounts.
+var mountsMutex sync.RWMutex
+
+// Mounts returns current mount table sorted by name.
+// Table is empty if fs.root is the root of the VFS.
+func Mounts() []*Mount {
+	mountsMutex.RLock()
+	defer mountsMutex.RUnlock()
+
+	return mounts
+}
+
+// parseMounts builds mount table from the configuration.
+func parseMounts(cfg *config.Config) (table []*Mount, err os.Error) {
+	entries, _ := cfg.GetList("fs.mounts")
+	readOnly, _ := cfg.GetList("fs.readonly")
+	hidden, _ := cfg.GetList("fs.hidden")
+
+	byName := make(map[string]*Mount)
+	table = make([]*Mount, 0, len(entries))
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i == -1 {
+			return nil, os.NewError(fmt.Sprintf("Mount '%s' should be name=path", entry))
+		}
+
+		m := &Mount{Name: strings.TrimSpace(entry[:i]), Root: strings.TrimSpace(entry[i+1:])}
+		if m.Name == "" || strings.Contains(m.Name, "/") || m.Name == "." || m.Name == ".." {
+			return nil, os.NewError(fmt.Sprintf("Bad mount name '%s'", m.Name))
+		}
+		if _, ok := byName[m.Name]; ok {
+			return nil, os.NewError(fmt.Sprintf("Mount '%s' is defined twice", m.Name))
+		}
+
+		byName[m.Name] = m
+		table = append(table, m)
+	}
+
+	for _, name := range readOnly {
+		m, ok := byName[name]
+		if !ok {
+			return nil, os.NewError(fmt.Sprintf("Read-only mount '%s' is not defined", name))
+		}
+		m.ReadOnly = true
+	}
+	for _, name := range hidden {
+		m, ok := byName[name]
+		if !ok {
+			return nil, os.NewError(fmt.Sprintf("Hidden mount '%s' is not defined", name))
+		}
+		m.Hidden = true
+	}
+
+	MountArray(table).Sort()
+
+	return table, nil
+}
+
+// validateMounts checks if mount table is correct. Mounted directories
+// aren't checked: unavailable mount (e. g. network one) is skipped till
+// mount table is rebuilt. fs.root is checked if it is used instead of mounts.
+func validateMounts(cfg *config.Config) os.Error {
+	table, err := parseMounts(cfg)
+	if err != nil {
+		return err
+	}
+	if len(table) == 0 {
+		return validateRoot(cfg)
+	}
+
+	return nil
+}
+
+// checkMount returns error if mounted directory is unavailable.
+func checkMount(m *Mount) os.Error {
+	fi, err := os.Stat(m.Root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDirectory() {
+		return os.NewError(fmt.Sprintf("'%s' is not a directory", m.Root))
+	}
+
+	return nil
+}
+
+// updateMounts rebuilds mount table from the configuration.
+func updateMounts(cfg *config.Config) {
+	table, err := parseMounts(cfg)
+	if err != nil {
+		log.Error("Mount table isn't changed. %s", err)
+		return
+	}
+
+	available := make([]*Mount, 0, len(table))
+	for _, m := range table {
+		if err := checkMount(m); err != nil {
+			log.Warning("Mount '%s' is unavailable and skipped. %s", m.Name, err)
+			continue
+		}
+		available = append(available, m)
+	}
+
+	mountsMutex.Lock()
+	mounts = available
+	mountsMutex.Unlock()
+
+	log.Info("Mount table is changed: %d mounts", len(available))
+	event.Notify(event.Library)
+}
+
+// findMount returns the mount the VFS path belongs to and the path relative
+// to the mount root. nil is returned for the VFS root and unknown mounts.
+func findMount(rootedPath string) (mount *Mount, relative string) {
+	p := path.Clean("/" + rootedPath)[1:]
+	name, relative := p, ""
+	if i := strings.Index(p, "/"); i != -1 {
+		name, relative = p[:i], p[i+1:]
+	}
+
+	for _, m := range Mounts() {
+		if m.Name == name {
+			return m, relative
+		}
+	}
+
+	return nil, ""
+}
+
+// isMountTableRoot returns true if path is the VFS root which contains mounts.
+func isMountTableRoot(p *Path) bool {
+	return len(Mounts()) > 0 && path.Clean("/"+p.Path()) == "/"
+}
+
+// listMounts returns directories of the visible mounts.
+func listMounts() []*Directory {
+	dirs := make([]*Directory, 0)
+	for _, m := range Mounts() {
+		if m.Hidden {
+			continue
+		}
+
+		dir, err := NewDirectory(NewPath("/" + m.Name))
+		if err != nil {
+			log.Warning("Mount '%s' is skipped. %s", m.Name, err)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// Package init function.
+func init() {
+	mounts, _ = parseMounts(&config.Configurations)
+
+	for _, key := range []string{"fs.mounts", "fs.readonly", "fs.hidden"} {
+		config.Watch(key, validateMounts, updateMounts)
+	}
+}
@@ -2,6 +2,8 @@ package vfs
 
 import (
 	. "path"
+	"os"
+	"fmt"
 	"strings"
 	"sort"
 	"./config"
@@ -41,11 +43,34 @@ func (path *Path) Path() string {
 	return path.rootedPath
 }
 
-// PathFull return full physical path (as used in OS).
+// PathFull return full physical path (as used in OS). If mounts are defined path
+// is resolved through the mount table. Empty string is returned for the VFS root
+// and paths of unknown mounts, they have no physical path.
 func (path *Path) PathFull() string {
-	root, _ := config.Configurations.GetString("fs.root")
+	if len(Mounts()) == 0 {
+		root, _ := config.Configurations.GetString("fs.root")
+		return Join(root, path.rootedPath)
+	}
+
+	mount, relative := findMount(path.rootedPath)
+	if mount == nil {
+		return ""
+	}
+
+	return Join(mount.Root, relative)
+}
+
+// Open opens physical file (or directory) of the path. Files of the read-only
+// mount can be opened for reading only.
+func (path *Path) Open(flag int) (file *os.File, err os.Error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		mount, _ := findMount(path.rootedPath)
+		if mount != nil && mount.ReadOnly {
+			return nil, os.NewError(fmt.Sprintf("'%s' belongs to the read-only mount '%s'", path, mount.Name))
+		}
+	}
 
-	return Join(root, path.rootedPath)
+	return os.OpenFile(path.PathFull(), flag, 0644)
 }
 
 // PathArray is helper type for manipulating Path arrays.
@@ -2,7 +2,10 @@
 package vfs
 
 import (
+	"os"
+	"fmt"
 	"sort"
+	"sync"
 	"./audio"
 )
 
@@ -12,10 +15,21 @@ type Track struct {
 	FilePath *Path
 	Number   int
 	Tag      *audio.Tag
-	// Length of the track in seconds.
-	// length int 
 }
 
+// fileLength is the length of the audio file read by the decoder.
+type fileLength struct {
+	// Modification time of the file the length was read at.
+	mtime int64
+	// Length of the file in seconds.
+	length int
+}
+
+// Lengths of the audio files. Key is the full file name.
+var lengths = make(map[string]fileLength)
+// Mutex for protecting lengths.
+var lengthsMutex sync.Mutex
+
 // NewTrack returns new initialized track indentify some audio file and track.
 func NewTrack(filePath *Path, number int) *Track {
 	track := new(Track)
@@ -25,14 +39,63 @@ func NewTrack(filePath *Path, number int) *Track {
 	return track
 }
 
-// Len returns length of the track in seconds.
+// Len returns length of the track in seconds or 0 if it is unknown.
+// Length is read on the first request and cached till the file is changed.
 func (track *Track) Len() int {
-	return 0
+	if track.Number != 0 {
+		return 0 // Length of the cue sheet track is unknown.
+	}
+
+	filename := track.FilePath.PathFull()
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+
+	lengthsMutex.Lock()
+	cached, ok := lengths[filename]
+	lengthsMutex.Unlock()
+	if ok && cached.mtime == fi.Mtime_ns {
+		return cached.length
+	}
+
+	length := track.readLength()
+
+	lengthsMutex.Lock()
+	lengths[filename] = fileLength{fi.Mtime_ns, length}
+	lengthsMutex.Unlock()
+
+	return length
 }
 
 // LenString returns length of the track in standard time format.
 func (track *Track) LenString() string {
-	return "0:00"
+	length := track.Len()
+
+	return fmt.Sprintf("%d:%02d", length/60, length%60)
+}
+
+// readLength takes length of the track from the audio decoder. Tags don't
+// keep the length, so the file header has to be read.
+func (track *Track) readLength() int {
+	filename := track.FilePath.PathFull()
+	decoder, err := audio.GetDecoder(filename)
+	if err != nil {
+		return 0
+	}
+
+	err = decoder.Open(filename)
+	if err != nil {
+		log.Warning("Length of '%s' is unknown. %s", track.FilePath.Path(), err)
+		return 0
+	}
+	defer decoder.Close()
+
+	if length := decoder.Length(); length > 0 {
+		return length
+	}
+
+	return 0
 }
 
 // TrackArray is helper type for manipulating Track arrays.
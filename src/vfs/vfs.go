@@ -9,9 +9,14 @@ import (
 	"strconv"
 	"cue"
 	"./audio"
+	"./logger"
 	"./config"
+	"./event"
 )
 
+// Log of the vfs subsystem.
+var log = logger.New("vfs")
+
 const (
 	PlaylistName = "*vfs*"
 )
@@ -45,20 +50,34 @@ func (fs *Filesystem) WorkingDirFull() string {
 	return fs.wd.PathFull()
 }
 
-// SetWorkingDir sets new working directory, -- directory where we are located in.
-func (fs *Filesystem) SetWorkingDir(dir string) os.Error {
-	root, _ := config.Configurations.GetString("fs.root")
+// Resolve returns VFS path for the given absolute or working directory relative path.
+// Resulting path can't be upper than root.
+func (fs *Filesystem) Resolve(filename string) *Path {
+	if path.IsAbs(filename) {
+		return NewPath(path.Clean(filename))
+	}
 
-	var newWd *Path
-	if path.IsAbs(dir) {
-		newWd = NewPath(dir)
-	} else {
-		newWd = NewPath(path.Join(fs.wd.Path(), dir))
+	return NewPath(path.Join(fs.wd.Path(), filename))
+}
 
-		// New path can't be upper than root.
-		if !strings.HasPrefix(newWd.PathFull(), root) {
-			newWd = NewPath("/")
-		}
+// IsDirectory returns true if path is an existing directory
+// (including the VFS root with mounts).
+func (fs *Filesystem) IsDirectory(dir *Path) bool {
+	if isMountTableRoot(dir) {
+		return true
+	}
+
+	fi, err := os.Stat(dir.PathFull())
+
+	return err == nil && fi.IsDirectory()
+}
+
+// SetWorkingDir sets new working directory, -- directory where we are located in.
+func (fs *Filesystem) SetWorkingDir(dir string) os.Error {
+	newWd := fs.Resolve(dir)
+	if isMountTableRoot(newWd) {
+		fs.wd = newWd
+		return nil
 	}
 
 	fileInfo, err := os.Stat(newWd.PathFull())
@@ -74,9 +93,9 @@ func (fs *Filesystem) SetWorkingDir(dir string) os.Error {
 	return nil
 }
 
-// getDirs returns sorted list of directories in the working folder.
-func (fs *Filesystem) getDirs() (dirs []*Directory, err os.Error) {
-	wd, err := os.Open(fs.WorkingDirFull())
+// getDirs returns sorted list of directories in the given folder.
+func (fs *Filesystem) getDirs(dir *Path) (dirs []*Directory, err os.Error) {
+	wd, err := dir.Open(os.O_RDONLY)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +108,7 @@ func (fs *Filesystem) getDirs() (dirs []*Directory, err os.Error) {
 
 	dirs = make([]*Directory, 0, len(dirnames))
 	for _, file := range dirnames {
-		filePath := NewPath(path.Join(fs.WorkingDir(), file))
+		filePath := NewPath(path.Join(dir.Path(), file))
 		fi, err := os.Stat(filePath.PathFull())
 		if err != nil {
 			return nil, err
@@ -100,7 +119,7 @@ func (fs *Filesystem) getDirs() (dirs []*Directory, err os.Error) {
 			if err == nil {
 				dirs = append(dirs, dir)
 			} else {
-				// TODO: Write waring into log.
+				log.Warning("Directory '%s' is skipped. %s", filePath.Path(), err)
 			}
 		}
 	}
@@ -128,27 +147,26 @@ func (fs *Filesystem) newTrack(path *Path) (track *Track, err os.Error) {
 	return
 }
 
-// getTracks returns list of the tracks in the current folder.
-func (fs *Filesystem) getTracks() (tracks []*Track, err os.Error) {
-	wd, err := os.Open(fs.WorkingDirFull())
+// getFiles returns sorted lists of the cue sheets and supported audio files in the given folder.
+func (fs *Filesystem) getFiles(dir *Path) (cueFiles []*Path, audioFiles []*Path, err os.Error) {
+	wd, err := dir.Open(os.O_RDONLY)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer wd.Close()
 
 	dirnames, err := wd.Readdirnames(-1)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// audioFiles is the list of regular audio files, they have no any relation to cue.
-	audioFiles := make([]*Path, 0, len(dirnames))
-	cueFiles := make([]*Path, 0, len(dirnames))
+	audioFiles = make([]*Path, 0, len(dirnames))
+	cueFiles = make([]*Path, 0, len(dirnames))
 	for _, file := range dirnames {
-		filePath := NewPath(path.Join(fs.WorkingDir(), file))
+		filePath := NewPath(path.Join(dir.Path(), file))
 		fi, err := os.Stat(filePath.PathFull())
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if fi.IsRegular() {
@@ -169,71 +187,95 @@ func (fs *Filesystem) getTracks() (tracks []*Track, err os.Error) {
 	PathArray(cueFiles).Sort()
 	PathArray(audioFiles).Sort()
 
+	return cueFiles, audioFiles, nil
+}
+
+// getCueTracks returns tracks of the cue sheet located in the given folder
+// and base names of the audio files these tracks belong to.
+func (fs *Filesystem) getCueTracks(dir *Path, cueFile *Path) (tracks []*Track, fileNames []string, err os.Error) {
+	file, err := cueFile.Open(os.O_RDONLY)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cueSheet, err := cue.Parse(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	tracks = make([]*Track, 0, 10)
+	fileNames = make([]string, 0, len(cueSheet.Files))
+	for _, cueFile := range cueSheet.Files {
+		fileNames = append(fileNames, path.Base(cueFile.Name))
 
-	// Process cue files firs.
-	for _, cueFile := range cueFiles {
-		file, err := os.Open(cueFile.PathFull())
+		filePath := NewPath(path.Join(dir.Path(), cueFile.Name))
+
+		// Check if we can decode this file.
+		_, err := audio.NewTagReader(filePath.PathFull())
 		if err != nil {
-			panic(err) // TOOD: Write log error message.
+			log.Warning("Cue sheet tracks of '%s' are skipped. %s", filePath.Path(), err)
 			continue
 		}
 
-		cueSheet, err := cue.Parse(file)
+		for _, cueTrack := range cueFile.Tracks {
+			track := NewTrack(filePath, cueTrack.Number)
+			track.Tag = new(audio.Tag)
+			if len(cueTrack.Performer) > 0 {
+				track.Tag.Artist = cueTrack.Performer
+			} else {
+				track.Tag.Artist = cueSheet.Performer
+			}
+			track.Tag.Album = cueSheet.Title
+			track.Tag.Title = cueTrack.Title
+			track.Tag.Number = strconv.Itoa(cueTrack.Number)
+
+			tracks = append(tracks, track)
+		}
+	}
+
+	return tracks, fileNames, nil
+}
+
+// getTracks returns list of the tracks in the given folder.
+func (fs *Filesystem) getTracks(dir *Path) (tracks []*Track, err os.Error) {
+	cueFiles, audioFiles, err := fs.getFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks = make([]*Track, 0, 10)
+
+	// Process cue files firs.
+	for _, cueFile := range cueFiles {
+		cueTracks, fileNames, err := fs.getCueTracks(dir, cueFile)
 		if err != nil {
-			panic(err) // TODO: Write log error message.
+			log.Warning("Cue sheet '%s' is skipped. %s", cueFile.Path(), err)
 			continue
 		}
+		tracks = append(tracks, cueTracks...)
 
-		for _, cueFile := range cueSheet.Files {
-			// Remove this file from the audioFiles
-			fileName := path.Base(cueFile.Name)
+		// Remove these files from the audioFiles
+		for _, fileName := range fileNames {
 			for i := 0; i < len(audioFiles); i++ {
-				audioFileName := path.Base(audioFiles[i].Path())
-				if audioFileName == fileName {
+				if audioFiles[i] != nil && path.Base(audioFiles[i].Path()) == fileName {
 					audioFiles[i] = nil
 					break
 				}
 			}
-
-			filePath := NewPath(path.Join(fs.WorkingDir(), cueFile.Name))
-
-			// Check if we can decode this file.
-			_, err := audio.NewTagReader(filePath.PathFull())
-			if err != nil {
-				panic(err) // TODO: Write warning message to log.
-			}
-
-			for _, cueTrack := range cueFile.Tracks {
-				track := NewTrack(filePath, cueTrack.Number)
-				track.Tag = new(audio.Tag)
-				if len(cueTrack.Performer) > 0 {
-					track.Tag.Artist = cueTrack.Performer
-				} else {
-					track.Tag.Artist = cueSheet.Performer
-				}
-				track.Tag.Album = cueSheet.Title
-				track.Tag.Title = cueTrack.Title
-				track.Tag.Number = strconv.Itoa(cueTrack.Number)
-
-				tracks = append(tracks, track)
-			}
 		}
 	}
 
-	newAudioFiles := make([]*Path, 0, len(audioFiles))
+	// Process non cue audio files.
 	for _, audioFile := range audioFiles {
-		if audioFile != nil {
-			newAudioFiles = append(newAudioFiles, audioFile)
+		if audioFile == nil {
+			continue
 		}
-	}
-	audioFiles = newAudioFiles
 
-	// Process non cue audio files.
-	for _, audioFile := range audioFiles {
 		track, err := fs.newTrack(audioFile)
 		if err != nil {
-			panic(err) // TODO: Write log warning.
+			log.Warning("Track '%s' is skipped. %s", audioFile.Path(), err)
+			continue
 		}
 
 		tracks = append(tracks, track)
@@ -244,12 +286,26 @@ func (fs *Filesystem) getTracks() (tracks []*Track, err os.Error) {
 
 // List returns content of the working directory.
 func (fs *Filesystem) List() (entries []*Entry, err os.Error) {
-	dirs, err := fs.getDirs()
+	return fs.ListDir(fs.wd)
+}
+
+// ListDir returns content of the given directory.
+// Root of the VFS with mounts lists visible mounts.
+func (fs *Filesystem) ListDir(dir *Path) (entries []*Entry, err os.Error) {
+	if isMountTableRoot(dir) {
+		entries = make([]*Entry, 0)
+		for _, dir := range listMounts() {
+			entries = append(entries, NewEntry(TypeDirectory, dir))
+		}
+		return entries, nil
+	}
+
+	dirs, err := fs.getDirs(dir)
 	if err != nil {
 		return nil, fmt.Errorf("Directory listing failed. %s", err.String())
 	}
 
-	tracks, err := fs.getTracks()
+	tracks, err := fs.getTracks(dir)
 	if err != nil {
 		return nil, fmt.Errorf("Directory listing failed. %s", err.String())
 	}
@@ -264,3 +320,95 @@ func (fs *Filesystem) List() (entries []*Entry, err os.Error) {
 
 	return entries, nil
 }
+
+// Track returns track for the given audio file and track number.
+// For single track files number is 0. Only the file itself (or cue sheets
+// of its directory for cue tracks) is read.
+func (fs *Filesystem) Track(filename *Path, number int) (track *Track, err os.Error) {
+	notFound := os.NewError(fmt.Sprintf("Track '%s:%d' not found", filename, number))
+
+	if number == 0 {
+		fi, err := os.Stat(filename.PathFull())
+		if err != nil || !fi.IsRegular() {
+			return nil, notFound
+		}
+
+		return fs.newTrack(filename)
+	}
+
+	dir := NewPath(path.Dir(filename.Path()))
+	cueFiles, _, err := fs.getFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cueFile := range cueFiles {
+		tracks, _, err := fs.getCueTracks(dir, cueFile)
+		if err != nil {
+			continue
+		}
+
+		for _, track := range tracks {
+			if track.Number == number && track.FilePath.Path() == filename.Path() {
+				return track, nil
+			}
+		}
+	}
+
+	return nil, notFound
+}
+
+// TracksRecursive returns all tracks from the given directory and its subdirectories.
+// Order is the same as in directories listing: subdirectories first.
+func (fs *Filesystem) TracksRecursive(dir *Path) (tracks []*Track, err os.Error) {
+	tracks = make([]*Track, 0)
+
+	entries, err := fs.ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		switch entry.Type() {
+		case TypeDirectory:
+			subTracks, err := fs.TracksRecursive(entry.Directory().Filename)
+			if err != nil {
+				return nil, err
+			}
+			tracks = append(tracks, subTracks...)
+		case TypeTrack:
+			tracks = append(tracks, entry.Track())
+		}
+	}
+
+	return tracks, nil
+}
+
+// validateRoot checks if configured library root is an existing directory.
+// fs.root isn't checked if mounts are defined, because it is ignored.
+func validateRoot(cfg *config.Config) os.Error {
+	if mounts, _ := cfg.GetList("fs.mounts"); len(mounts) > 0 {
+		return nil
+	}
+
+	root, _ := cfg.GetString("fs.root")
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDirectory() {
+		return os.NewError(fmt.Sprintf("'%s' is not a directory", root))
+	}
+
+	return nil
+}
+
+// Package init function.
+func init() {
+	// Paths are resolved against the current root, so new root is used right away.
+	config.Watch("fs.root", validateRoot, func(cfg *config.Config) {
+		root, _ := cfg.GetString("fs.root")
+		log.Info("Library root is changed to '%s'", root)
+		event.Notify(event.Library)
+	})
+}
@@ -0,0 +1,210 @@
+// WebSocket server implementation.
+package server
+
+import (
+	"os"
+	"net"
+	"http"
+	"bufio"
+	"bytes"
+	"sync"
+	"strings"
+	"websocket"
+)
+
+// wsServer represents server which accepts WebSocket connections.
+// Every text frame recieved from the client is one command which is passed
+// to the CommandHandler as is (protocol handler accepts JSON-framed commands too).
+// Every response and pushed event is sent as separate JSON document frame.
+type wsServer struct {
+	listener net.Listener
+	// HTTP path WebSocket connections are accepted on.
+	path string
+	// Origins browser pages are allowed to connect from.
+	origins []string
+	// Connected clients.
+	sessions          *sessions
+	connectionHandler ConnectionHandler
+}
+
+// NewWebSocketServer creates newly initialized WebSocket server, which listens
+// address (host:port) and accepts connections on the given HTTP path.
+// Browsers connections are accepted only from the pages of the given origins
+// (e. g. http://player.local:8080) or from the endpoint host itself. Clients
+// which don't send Origin header are not browsers, so they are always accepted.
+func NewWebSocketServer(address string, path string, origins []string) (srv Server, err os.Error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := new(wsServer)
+	server.listener = listener
+	server.path = path
+	server.origins = origins
+	server.sessions = newSessions()
+
+	return server, nil
+}
+
+// Set connection handler.
+func (srv *wsServer) SetConnectionHandler(handler ConnectionHandler) {
+	srv.connectionHandler = handler
+}
+
+// Serve starts WebSocket server main loop.
+func (srv *wsServer) Serve() os.Error {
+	if srv.connectionHandler == nil {
+		return os.NewError("SetConnectionHandler should be call first")
+	}
+
+	wsHandler := websocket.Handler(func(ws *websocket.Conn) {
+		srv.handleClient(ws)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(srv.path, func(w http.ResponseWriter, r *http.Request) {
+		if !srv.allowedOrigin(r) {
+			log.Warning("WebSocket connection from %s with origin '%s' is rejected",
+				r.RemoteAddr, r.Header.Get("Origin"))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		wsHandler.ServeHTTP(w, r)
+	})
+
+	err := http.Serve(srv.listener, mux)
+	if srv.sessions.isClosing() {
+		return nil // Listener was closed by Shutdown.
+	}
+
+	return err
+}
+
+// allowedOrigin returns true if the request's Origin is the endpoint host
+// or one of the allowed origins.
+func (srv *wsServer) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	url, err := http.ParseURL(origin)
+	if err == nil && url.Host == r.Host {
+		return true
+	}
+	for _, o := range srv.origins {
+		if strings.TrimRight(o, "/") == strings.TrimRight(origin, "/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Shutdown implements Server interface.
+func (srv *wsServer) Shutdown() {
+	srv.sessions.stop()
+	srv.listener.Close()
+	srv.sessions.wait()
+}
+
+// handleClient organize communication between WebSocket client and CommandHandler.
+func (srv *wsServer) handleClient(ws *websocket.Conn) {
+	defer ws.Close()
+
+	if !srv.sessions.add() {
+		return
+	}
+	defer srv.sessions.remove()
+
+	commandHandler := srv.connectionHandler.HandleConnection(ws)
+	if commandHandler == nil {
+		return
+	}
+
+	c := addClient(ws, commandHandler)
+	if c == nil {
+		message := rejectTooManyClients(ws, commandHandler)
+		websocket.Message.Send(ws, strings.TrimRight(message, "\n"))
+		return
+	}
+	defer removeClient(c)
+
+	// WebSocket clients always talk JSON.
+	discard := bufio.NewWriter(new(bytes.Buffer))
+	commandHandler.HandleCommand(discard, "FORMAT json")
+
+	frames := &frameWriter{ws: ws}
+
+	quit := make(chan bool)
+	defer close(quit)
+
+	if source, ok := commandHandler.(EventSource); ok {
+		go func() {
+			for msg := range source.Events(quit) {
+				frames.Write([]byte(msg + "\n"))
+			}
+		}()
+	}
+
+	lines := make(chan string)
+	go readFrames(ws, lines, quit)
+
+	serveSession(c, lines, bufio.NewWriter(frames), srv.sessions.shutdown, nil)
+}
+
+// readFrames reads commands from the WebSocket client and sends them
+// as protocol lines to the channel. Channel is closed when connection is closed
+// or client sends frame longer than maxLineLength.
+func readFrames(ws *websocket.Conn, lines chan<- string, quit <-chan bool) {
+	defer close(lines)
+
+	for {
+		var frame string
+		err := websocket.Message.Receive(ws, &frame)
+		if err != nil || len(frame) > maxLineLength {
+			return
+		}
+
+		line := strings.TrimSpace(frame)
+
+		select {
+		case lines <- line:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// frameWriter is the io.Writer which sends every written line as separate
+// WebSocket frame. It is safe to use it from several goroutines.
+type frameWriter struct {
+	ws *websocket.Conn
+	// Incomplete line.
+	buf bytes.Buffer
+	// Mutex for protecting buf and frames sending.
+	mutex sync.Mutex
+}
+
+// Write implements io.Writer interface.
+func (w *frameWriter) Write(data []byte) (n int, err os.Error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buf.Write(data)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i == -1 {
+			break
+		}
+
+		frame := string(w.buf.Next(i + 1))
+		err = websocket.Message.Send(w.ws, strings.TrimRight(frame, "\n"))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}